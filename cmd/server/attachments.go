@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gmail-tts-app/internal/domain/message"
+)
+
+// attachmentMode decides how attachment text is narrated.
+type attachmentMode string
+
+const (
+	attachAppend   attachmentMode = "append"   // after the body, in the same chapter
+	attachChapters attachmentMode = "chapters" // each attachment as its own chapter
+	attachSkip     attachmentMode = "skip"
+)
+
+func parseAttachmentMode(v string) (attachmentMode, error) {
+	switch m := attachmentMode(strings.ToLower(strings.TrimSpace(v))); m {
+	case "":
+		return attachAppend, nil
+	case attachAppend, attachChapters, attachSkip:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown attachment mode %q (want append, chapters or skip)", v)
+}
+
+// withAttachments returns the text to narrate for msg: the body followed by
+// the attachment texts according to mode. Form feeds of the body and the
+// attachments (PDF page breaks, ...) become line breaks, so only the chapters
+// made here split the episode.
+func withAttachments(msg *message.EmailMessage, mode attachmentMode) string {
+	body := noChapterBreaks(msg.Body)
+	if mode == attachSkip || len(msg.Attachments) == 0 {
+		return body
+	}
+	sep := "\n\n"
+	if mode == attachChapters {
+		sep = "\n" + message.ChapterBreak + "\n"
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(body))
+	for _, a := range msg.Attachments {
+		b.WriteString(sep)
+		fmt.Fprintf(&b, "添付ファイル「%s」\n\n%s", a.Filename, noChapterBreaks(a.Text))
+	}
+	return b.String()
+}
+
+func noChapterBreaks(s string) string {
+	return strings.ReplaceAll(s, message.ChapterBreak, "\n")
+}
+
+// splitChapters splits raw text at chapter breaks, dropping empty chapters.
+func splitChapters(text string) []string {
+	var chapters []string
+	for _, c := range strings.Split(text, message.ChapterBreak) {
+		if strings.TrimSpace(c) != "" {
+			chapters = append(chapters, c)
+		}
+	}
+	if len(chapters) == 0 {
+		return []string{text}
+	}
+	return chapters
+}
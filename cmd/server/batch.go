@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/domain/state"
+)
+
+// runResult summarizes the outcome of processing a single message in a batch run.
+type runResult struct {
+	ID        message.ID
+	Subject   string
+	Started   time.Time
+	Duration  time.Duration
+	AudioPath string
+	Stage     state.Stage // stage that failed (empty on success)
+	Err       error
+	Warning   string
+}
+
+func (r runResult) ok() runResult {
+	r.Duration = time.Since(r.Started)
+	return r
+}
+
+func (r runResult) fail(stage state.Stage, err error) runResult {
+	r.Duration = time.Since(r.Started)
+	r.Stage = stage
+	r.Err = err
+	log.Printf("[flow] %s failed at %s: %v", r.ID, stage, err)
+	return r
+}
+
+func failedCount(results []runResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// logRunSummary prints one line per processed message and the totals.
+func logRunSummary(results []runResult) {
+	log.Printf("[summary] ===== run summary (%d message(s)) =====", len(results))
+	for _, r := range results {
+		if r.Err != nil {
+			log.Printf("[summary] NG  %s subject=%q stage=%s elapsed=%s err=%v", r.ID, r.Subject, r.Stage, r.Duration.Round(time.Second), r.Err)
+			continue
+		}
+		line := "[summary] OK  %s subject=%q elapsed=%s audio=%s"
+		if r.Warning != "" {
+			log.Printf(line+" warning=%s", r.ID, r.Subject, r.Duration.Round(time.Second), r.AudioPath, r.Warning)
+			continue
+		}
+		log.Printf(line, r.ID, r.Subject, r.Duration.Round(time.Second), r.AudioPath)
+	}
+	failed := failedCount(results)
+	log.Printf("[summary] succeeded=%d failed=%d", len(results)-failed, failed)
+}
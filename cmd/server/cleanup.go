@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/infrastructure/textclean"
+)
+
+// cleanupReportName is written next to the cleaned text.
+const cleanupReportName = "cleanup_report.json"
+
+// newCleaner builds the cleaner from the CLEANUP_* settings and the rules in rulesFile.
+func newCleaner(cfg *config.Config, rulesFile string) (*textclean.Cleaner, error) {
+	rules, err := textclean.LoadRules(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("load cleanup rules: %w", err)
+	}
+	return textclean.NewCleaner(strings.Split(cfg.CleanupDetectors, ","), rules)
+}
+
+// cleanRawText strips the noise from the raw text file and saves the result
+// under text/clean_txt/{id}/ with the same file name, so later stages still
+// find the message ID in it. The report goes next to it.
+func cleanRawText(rawPath string, c *textclean.Cleaner, src textclean.Source) (string, textclean.Report, error) {
+	raw, err := os.ReadFile(rawPath)
+	if err != nil {
+		return "", textclean.Report{}, fmt.Errorf("read raw text: %w", err)
+	}
+	cleaned, rep := c.Clean(string(raw), src)
+
+	msgID := extractMessageIDFromPath(rawPath)
+	cleanDir := filepath.Join("text", "clean_txt", msgID)
+	if err := os.MkdirAll(cleanDir, 0o755); err != nil {
+		return "", rep, fmt.Errorf("create clean dir: %w", err)
+	}
+	cleanPath := filepath.Join(cleanDir, filepath.Base(rawPath))
+	if err := writeFileAtomic(cleanPath, []byte(cleaned), 0o644); err != nil {
+		return "", rep, fmt.Errorf("write cleaned text: %w", err)
+	}
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return "", rep, err
+	}
+	if err := writeFileAtomic(filepath.Join(cleanDir, cleanupReportName), data, 0o644); err != nil {
+		return "", rep, fmt.Errorf("write cleanup report: %w", err)
+	}
+
+	var steps []string
+	for _, s := range rep.Steps {
+		if s.Removed > 0 {
+			steps = append(steps, fmt.Sprintf("%s=%d", s.Name, s.Removed))
+		}
+	}
+	log.Printf("[clean] removed %d of %d chars (%s): %s", rep.Removed(), rep.Before, strings.Join(steps, " "), cleanPath)
+	return cleanPath, rep, nil
+}
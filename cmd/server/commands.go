@@ -0,0 +1,1279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/domain/state"
+	"gmail-tts-app/internal/infrastructure/gmail"
+	"gmail-tts-app/internal/infrastructure/googleauth"
+	"gmail-tts-app/internal/infrastructure/imap"
+	"gmail-tts-app/internal/infrastructure/localfile"
+	"gmail-tts-app/internal/infrastructure/mailparse"
+	"gmail-tts-app/internal/infrastructure/mbox"
+	"gmail-tts-app/internal/infrastructure/pronunciation"
+	"gmail-tts-app/internal/infrastructure/statestore"
+
+	"gopkg.in/yaml.v3"
+)
+
+// command is a CLI subcommand.
+type command struct {
+	name    string
+	args    string // argument synopsis for usage
+	summary string
+	run     func(ctx context.Context, cfg *config.Config, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[-max N] [-force stages] [-thread] [-profile names] [id...]", "run the full flow for new messages of every profile (or the given message / thread-<id> IDs)", cmdRun},
+		{"daemon", "[-interval d] [-cron expr] [-max N] [-force stages] [-profile names]", "poll Gmail on a schedule and run the full flow", cmdDaemon},
+		{"serve", "[-max N] [-force stages] [-profile names]", "serve the Pub/Sub push endpoint and run on Gmail notifications", cmdServe},
+		{"local", "[-force stages] <file>...", "generate episodes from local .txt/.md/.eml/.html files", cmdLocal},
+		{"import-mbox", "[-query q] [-max N] [-dry-run] [-force stages] <file.mbox>", "generate episodes from an mbox / Google Takeout archive", cmdImportMbox},
+		{"imap", "[-subject s] [-from f] [-since date] [-max N] [-dry-run] [-force stages]", "generate episodes from an IMAP mailbox (IMAP_ADDR, IMAP_USERNAME, IMAP_PASSWORD)", cmdImap},
+		{"fetch", "<id>...", "save the message body to text/raw_txt/<id>/", cmdFetch},
+		{"clean", "<id|raw.txt>...", "strip signatures, quotes and newsletter footers into text/clean_txt/<id>/", cmdClean},
+		{"convert", "[-force] <id|raw.txt>...", "clean (unless CLEANUP_ENABLED=false) and convert raw text to podcast parts in text/podcast_txt/<id>/", cmdConvert},
+		{"synthesize", "[-force] <id|podcast dir|part.json|part.txt>...", "synthesize podcast parts to audio/parts/<id>/", cmdSynthesize},
+		{"terms", "[-json] [-profile name] <id|file>...", "list Latin-script terms the pronunciation dictionary does not cover yet", cmdTerms},
+		{"merge", "<id>...", "merge audio parts into audio/merged/<id>/", cmdMerge},
+		{"upload", "[-force] <id|file.mp3>...", "upload the merged episode (or any mp3) to Drive", cmdUpload},
+		{"status", "[id...]", "show per-message progress from the state store", cmdStatus},
+		{"config", "[-max N] [-attachments mode] [-thread] [-interval d] [-cron expr] print", "show the effective configuration (flags > env > config file > defaults) with secrets redacted", cmdConfig},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "usage: server [-config file] <command> [flags] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	fmt.Fprintln(w, "\n-config selects the YAML config file (overrides CONFIG_FILE, default config.yaml).")
+	fmt.Fprintln(w, "without a command, \"run\" is assumed. use \"<command> -h\" for flags.")
+	w.Flush()
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		c := findCommand(name)
+		fmt.Fprintf(fs.Output(), "usage: server %s %s\n", name, c.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// requireArgs fails with usage when no positional arguments were given.
+func requireArgs(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		return nil
+	}
+	fs.Usage()
+	return errors.New("missing arguments")
+}
+
+// runFlags are shared by the commands that run the full flow.
+type runFlags struct {
+	max         *int
+	force       *string
+	attachments *string
+}
+
+func addRunFlags(fs *flag.FlagSet) runFlags {
+	return runFlags{
+		max:         addMaxFlag(fs),
+		force:       fs.String("force", "", "regenerate outputs of these stages instead of reusing them: convert,synthesize,upload or all"),
+		attachments: addAttachmentsFlag(fs),
+	}
+}
+
+func addMaxFlag(fs *flag.FlagSet) *int {
+	return fs.Int("max", -1, "max number of unprocessed messages to handle per run (overrides MAX_MESSAGES_PER_RUN, 0 = no limit)")
+}
+
+// scheduleFlags are the daemon schedule flags.
+type scheduleFlags struct {
+	interval *time.Duration
+	cron     *string
+}
+
+func addScheduleFlags(fs *flag.FlagSet) scheduleFlags {
+	return scheduleFlags{
+		interval: fs.Duration("interval", 0, "poll interval (overrides POLL_INTERVAL)"),
+		cron:     fs.String("cron", "", "poll schedule as a 5-field cron expression (overrides POLL_CRON and -interval)"),
+	}
+}
+
+func (f scheduleFlags) apply(cfg *config.Config) {
+	if *f.interval > 0 {
+		cfg.PollInterval = *f.interval
+		cfg.PollCron = ""
+	}
+	if *f.cron != "" {
+		cfg.PollCron = *f.cron
+	}
+}
+
+// addProfileFlag adds -profile. Commands running the whole Gmail flow accept a
+// comma separated list (default: every profile), the others a single name
+// (default: the profile the message was picked up by, else the first one).
+func addProfileFlag(fs *flag.FlagSet) *string {
+	return fs.String("profile", "", "profile name(s) from PROFILES_FILE")
+}
+
+// loadProfiles loads the profiles file and keeps the ones named in names (all when empty).
+func loadProfiles(cfg *config.Config, names string) ([]config.Profile, error) {
+	profiles, err := config.LoadProfiles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("load profiles: %w", err)
+	}
+	return config.SelectProfiles(profiles, names)
+}
+
+// loadProfile loads the profile called name (the first one when empty).
+func loadProfile(cfg *config.Config, name string) (config.Profile, error) {
+	profiles, err := config.LoadProfiles(cfg)
+	if err != nil {
+		return config.Profile{}, fmt.Errorf("load profiles: %w", err)
+	}
+	prof, err := config.FindProfile(profiles, name)
+	if err != nil {
+		return config.Profile{}, err
+	}
+	return *prof, nil
+}
+
+// useProfile points p at the profile named by the -profile flag, else at the
+// one rec was picked up by, else at the first profile.
+func (p *pipeline) useProfile(profiles []config.Profile, flagName string, rec *state.Record) error {
+	name := flagName
+	if name == "" {
+		name = rec.Profile
+	}
+	prof, err := config.FindProfile(profiles, name)
+	if err != nil && flagName == "" {
+		// 設定から消えたプロファイルで処理されたメッセージは先頭のプロファイルで扱う
+		log.Printf("[cli] %s: %v. using profile %s", rec.MessageID, err, profiles[0].Name)
+		prof, err = &profiles[0], nil
+	}
+	if err != nil {
+		return err
+	}
+	p.profile = *prof
+	return nil
+}
+
+func addThreadFlag(fs *flag.FlagSet, cfg *config.Config) *bool {
+	return fs.Bool("thread", cfg.ThreadMode, "narrate whole Gmail threads instead of single messages (THREAD_MODE)")
+}
+
+func addAttachmentsFlag(fs *flag.FlagSet) *string {
+	return fs.String("attachments", "", "PDF/TXT/DOCX attachments: append, chapters or skip (overrides ATTACHMENT_MODE; profiles with their own attachment_mode keep it)")
+}
+
+// applyAttachments overrides and validates the attachment mode.
+func applyAttachments(cfg *config.Config, v string) error {
+	if v != "" {
+		cfg.AttachmentMode = v
+	}
+	mode, err := parseAttachmentMode(cfg.AttachmentMode)
+	if err != nil {
+		return err
+	}
+	cfg.AttachmentMode = string(mode)
+	return nil
+}
+
+func (f runFlags) apply(cfg *config.Config) (map[state.Stage]bool, error) {
+	if *f.max >= 0 {
+		cfg.MaxMessagesPerRun = *f.max
+	}
+	if err := applyAttachments(cfg, *f.attachments); err != nil {
+		return nil, fmt.Errorf("invalid -attachments: %w", err)
+	}
+	force, err := parseForceStages(*f.force)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -force: %w", err)
+	}
+	return force, nil
+}
+
+// singleForce returns the force set for a command that runs only stage.
+func singleForce(force bool, stage state.Stage) map[state.Stage]bool {
+	return map[state.Stage]bool{stage: force}
+}
+
+// openStateStore opens the state store and imports the legacy processed-ID
+// list on first use.
+func openStateStore(cfg *config.Config) (*statestore.BoltStore, error) {
+	store, err := statestore.NewBoltStore(cfg.StateDBPath)
+	if err != nil {
+		return nil, err
+	}
+	// 初回のみ旧 procced_mail_ids.txt と gmail_history.json から取り込む
+	if _, err := store.ImportLegacyIDs(cfg.LegacyProcessedIDsPath); err != nil {
+		store.Close()
+		return nil, err
+	}
+	if _, err := store.ImportLegacyHistory(cfg.LegacyHistoryPath); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// newGmailPipeline authorizes Gmail (and Drive when uploads are enabled) and
+// builds a pipeline reading messages from Gmail for profiles.
+func newGmailPipeline(ctx context.Context, cfg *config.Config, store *statestore.BoltStore, force map[state.Stage]bool, profiles []config.Profile) (*pipeline, *gmail.MessageRepository, error) {
+	actions, err := gmail.ParsePostActions(cfg.GmailPostActions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid GMAIL_POST_ACTIONS: %w", err)
+	}
+	if err := cfg.RequireOpenAIKey(); err != nil {
+		return nil, nil, err
+	}
+
+	// 1-2) Gmailアクセス可否を確認し、必要なら認証を促す
+	srv, err := ensureGmailService(ctx, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("obtain gmail service: %w", err)
+	}
+
+	if err := drivePreflight(ctx, cfg); err != nil {
+		return nil, nil, err
+	}
+
+	msgRepo := gmail.NewMessageRepositoryWithHistory(srv, store)
+	// 添付ファイルはどのプロファイルでも読まないときだけダウンロードを省く
+	skip := true
+	for _, prof := range profiles {
+		skip = skip && prof.AttachmentMode == string(attachSkip)
+	}
+	if skip {
+		msgRepo.WithoutAttachments()
+	}
+	// 付与するラベルを先に作っておく（権限不足ならここで気付ける）
+	if err := msgRepo.EnsureLabels(ctx, actions); err != nil {
+		return nil, nil, fmt.Errorf("prepare post-action labels: %w", err)
+	}
+	return &pipeline{cfg: cfg, repo: msgRepo, state: store, force: force, postActions: actions}, msgRepo, nil
+}
+
+// drivePreflight asks for Drive authorization up front when uploads are enabled,
+// so a run does not stop for consent after the paid stages.
+func drivePreflight(ctx context.Context, cfg *config.Config) error {
+	// 2.1) Driveアップロードが有効なら、必要に応じてDriveの認証も事前に促す
+	if !cfg.DriveUploadEnabled {
+		return nil
+	}
+	if err := cfg.RequireGoogleCredentials(); err != nil {
+		return err
+	}
+	log.Printf("[drive] preflight: ensuring Drive authorization")
+	if _, err := ensureDriveService(ctx); err != nil {
+		return fmt.Errorf("drive preflight: %w", err)
+	}
+	return nil
+}
+
+func cmdRun(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("run")
+	rf := addRunFlags(fs)
+	thread := addThreadFlag(fs, cfg)
+	profileNames := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	force, err := rf.apply(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.ThreadMode = *thread
+	profiles, err := loadProfiles(cfg, *profileNames)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	log.Printf("[flow] starting run flow")
+	p, msgRepo, err := newGmailPipeline(ctx, cfg, store, force, profiles)
+	if err != nil {
+		return err
+	}
+
+	// ID指定時は処理済みかどうかに関わらず、そのメールだけを（先頭のプロファイルで）処理する
+	if fs.NArg() > 0 {
+		p = p.withProfile(profiles[0])
+		ids := make([]message.ID, 0, fs.NArg())
+		for _, id := range fs.Args() {
+			ids = append(ids, message.ID(id))
+		}
+		if cfg.ThreadMode {
+			if ids, err = msgRepo.ThreadIDsOf(ctx, ids); err != nil {
+				return err
+			}
+		}
+		var results []runResult
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				break
+			}
+			results = append(results, p.processMessage(ctx, id))
+		}
+		logRunSummary(results)
+		if failedCount(results) > 0 {
+			return errors.New("some messages failed")
+		}
+		return nil
+	}
+
+	runProfiles(ctx, p, msgRepo, profiles)
+	return nil
+}
+
+func cmdDaemon(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("daemon")
+	rf := addRunFlags(fs)
+	thread := addThreadFlag(fs, cfg)
+	profileNames := addProfileFlag(fs)
+	sf := addScheduleFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	force, err := rf.apply(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.ThreadMode = *thread
+	sf.apply(cfg)
+	sched, err := pollSchedule(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+	profiles, err := loadProfiles(cfg, *profileNames)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	p, msgRepo, err := newGmailPipeline(ctx, cfg, store, force, profiles)
+	if err != nil {
+		return err
+	}
+	runDaemon(ctx, sched, func(ctx context.Context) {
+		runProfiles(ctx, p, msgRepo, profiles)
+	})
+	return nil
+}
+
+func cmdServe(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("serve")
+	rf := addRunFlags(fs)
+	thread := addThreadFlag(fs, cfg)
+	profileNames := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	force, err := rf.apply(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.ThreadMode = *thread
+	profiles, err := loadProfiles(cfg, *profileNames)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	p, msgRepo, err := newGmailPipeline(ctx, cfg, store, force, profiles)
+	if err != nil {
+		return err
+	}
+	return runPushServer(ctx, p, msgRepo, profiles)
+}
+
+func cmdLocal(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("local")
+	forceStages := fs.String("force", "", "regenerate outputs of these stages instead of reusing them: convert,synthesize,upload or all")
+	attachments := addAttachmentsFlag(fs)
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	if err := applyAttachments(cfg, *attachments); err != nil {
+		return fmt.Errorf("invalid -attachments: %w", err)
+	}
+	force, err := parseForceStages(*forceStages)
+	if err != nil {
+		return fmt.Errorf("invalid -force: %w", err)
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := drivePreflight(ctx, cfg); err != nil {
+		return err
+	}
+
+	if err := cfg.RequireOpenAIKey(); err != nil {
+		return err
+	}
+	prof, err := loadProfile(cfg, *profileName)
+	if err != nil {
+		return err
+	}
+	repo := localfile.NewRepository()
+	p := &pipeline{cfg: cfg, repo: repo, state: store, force: force, profile: prof}
+	var results []runResult
+	for _, path := range fs.Args() {
+		if ctx.Err() != nil {
+			break
+		}
+		id, err := repo.Add(path)
+		if err != nil {
+			results = append(results, runResult{ID: message.ID(path), Started: time.Now()}.fail(state.StageFetched, err))
+			continue
+		}
+		log.Printf("[local] %s -> %s", path, id)
+		results = append(results, p.processMessage(ctx, id))
+	}
+	logRunSummary(results)
+	if failedCount(results) > 0 {
+		return errors.New("some files failed")
+	}
+	return nil
+}
+
+func cmdImportMbox(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("import-mbox")
+	rf := addRunFlags(fs)
+	query := fs.String("query", "", "Gmail-style filter (subject:, from:, to:, label:, after:, before:, ...); defaults to the query of the profile")
+	dryRun := fs.Bool("dry-run", false, "only list the messages that would be processed")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one mbox file is required")
+	}
+	force, err := rf.apply(cfg)
+	if err != nil {
+		return err
+	}
+	prof, err := loadProfile(cfg, *profileName)
+	if err != nil {
+		return err
+	}
+	q := *query
+	if q == "" {
+		q = prof.Query
+	}
+	filter, err := message.ParseQuery(q, time.Now())
+	if err != nil {
+		return err
+	}
+	if ignored := filter.Ignored(); len(ignored) > 0 {
+		log.Printf("[mbox] ignoring unsupported query terms: %s", strings.Join(ignored, " "))
+	}
+	log.Printf("[mbox] applying query: %s", q)
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	repo := mbox.NewRepository(f)
+	entries, err := repo.Load(func(m *mailparse.Message) bool {
+		return filter.Match(message.QueryTarget{
+			Subject: m.Subject,
+			From:    m.From(),
+			To:      m.To(),
+			Body:    m.Text,
+			Labels:  m.Labels(),
+			Date:    m.Date(),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if len(entries) > 0 {
+		backfillMessageIDHeaders(ctx, store)
+	}
+
+	// 処理済み（Gmail 経由で処理したものも Message-ID で照合）を除外する
+	var pending []mbox.Entry
+	for _, e := range entries {
+		rec, err := store.Get(string(e.ID))
+		if err != nil {
+			return err
+		}
+		if rec != nil && rec.Status == state.StatusDone {
+			continue
+		}
+		dup, err := store.FindByMessageIDHeader(e.MessageIDHeader)
+		if err != nil {
+			return err
+		}
+		if dup != nil && dup.MessageID != string(e.ID) && dup.Status == state.StatusDone {
+			log.Printf("[mbox] %s %q already processed as %s", e.ID, e.Subject, dup.MessageID)
+			continue
+		}
+		pending = append(pending, e)
+	}
+	if max := cfg.MaxMessagesPerRun; max > 0 && len(pending) > max {
+		pending = pending[:max]
+	}
+	log.Printf("[mbox] %d message(s) to process (matched=%d, cap=%d)", len(pending), len(entries), cfg.MaxMessagesPerRun)
+
+	if *dryRun {
+		for _, e := range pending {
+			fmt.Printf("%s\t%s\t%s\n", e.ID, e.Date.Local().Format("2006-01-02"), e.Subject)
+		}
+		return nil
+	}
+	if err := cfg.RequireOpenAIKey(); err != nil {
+		return err
+	}
+	if err := drivePreflight(ctx, cfg); err != nil {
+		return err
+	}
+
+	p := &pipeline{cfg: cfg, repo: repo, state: store, force: force, profile: prof}
+	var results []runResult
+	for _, e := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, p.processMessage(ctx, e.ID))
+	}
+	if len(results) > 0 {
+		logRunSummary(results)
+	}
+	if failedCount(results) > 0 {
+		return errors.New("some messages failed")
+	}
+	return nil
+}
+
+// backfillMessageIDHeaders looks up the Message-ID header of the done Gmail
+// records that have none (imported from procced_mail_ids.txt, or processed
+// before headers were recorded), so the mbox import recognizes them. Records
+// that get their header are not looked up again. Without Gmail access it only
+// warns: those messages would be processed again.
+func backfillMessageIDHeaders(ctx context.Context, store state.Store) {
+	recs, err := store.List()
+	if err != nil {
+		log.Printf("[mbox] cannot list state records: %v", err)
+		return
+	}
+	var legacy []*state.Record
+	for _, rec := range recs {
+		if rec.Status == state.StatusDone && rec.MessageIDHeader == "" && gmail.IsMessageID(rec.MessageID) {
+			legacy = append(legacy, rec)
+		}
+	}
+	if len(legacy) == 0 {
+		return
+	}
+	warn := func(n int, reason string) {
+		log.Printf("[mbox] WARNING: %d processed Gmail message(s) have no Message-ID header in the state store (%s); "+
+			"the same messages in the archive are not recognized and will be processed again", n, reason)
+	}
+	// 対話的な認証は始めない（mbox の取り込みだけなら Gmail は不要）
+	srv, err := googleauth.BuildGmailService(ctx)
+	if err != nil {
+		warn(len(legacy), fmt.Sprintf("no Gmail access to look them up: %v", err))
+		return
+	}
+	repo := gmail.NewMessageRepository(srv)
+	log.Printf("[mbox] looking up the Message-ID header of %d processed Gmail message(s)", len(legacy))
+	gone := 0
+	for i, rec := range legacy {
+		if ctx.Err() != nil {
+			return
+		}
+		header, err := repo.MessageIDHeader(ctx, message.ID(rec.MessageID))
+		if err == nil && header != "" {
+			rec.MessageIDHeader = header
+			err = store.Put(rec)
+		}
+		if err != nil {
+			// トークン切れなどは残りも同じく失敗するので打ち切る
+			warn(len(legacy)-i+gone, fmt.Sprintf("lookup failed: %v", err))
+			return
+		}
+		if header == "" {
+			gone++
+		}
+	}
+	if gone > 0 {
+		warn(gone, "deleted from Gmail or sent without the header")
+	}
+}
+
+func cmdImap(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("imap")
+	rf := addRunFlags(fs)
+	subject := fs.String("subject", "", "only messages whose Subject contains this text")
+	from := fs.String("from", "", "only messages whose From contains this text")
+	since := fs.String("since", "", "only messages received since this date (YYYY-MM-DD) or for this long (e.g. 720h)")
+	dryRun := fs.Bool("dry-run", false, "only list the messages that would be processed")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	force, err := rf.apply(cfg)
+	if err != nil {
+		return err
+	}
+	prof, err := loadProfile(cfg, *profileName)
+	if err != nil {
+		return err
+	}
+	crit := imap.SearchCriteria{Subject: *subject, From: *from}
+	if *since != "" {
+		if crit.Since, err = parseSince(*since, time.Now()); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	security, err := imap.ParseSecurity(cfg.IMAPSecurity)
+	if err != nil {
+		return err
+	}
+	repo := imap.NewRepository(imap.Config{
+		Addr:     cfg.IMAPAddr,
+		Username: cfg.IMAPUsername,
+		Password: cfg.IMAPPassword,
+		Mailbox:  cfg.IMAPMailbox,
+		Security: security,
+	})
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	p := &pipeline{cfg: cfg, repo: repo, state: store, force: force, profile: prof}
+
+	ids, err := repo.Search(ctx, crit)
+	if err != nil {
+		return err
+	}
+	pending, err := p.pendingMessageIDs(ids)
+	if err != nil {
+		return err
+	}
+	if max := cfg.MaxMessagesPerRun; max > 0 && len(pending) > max {
+		pending = pending[:max]
+	}
+	log.Printf("[imap] %d message(s) to process (matched=%d, cap=%d)", len(pending), len(ids), cfg.MaxMessagesPerRun)
+
+	if *dryRun {
+		for _, id := range pending {
+			fmt.Println(id)
+		}
+		return nil
+	}
+	if err := cfg.RequireOpenAIKey(); err != nil {
+		return err
+	}
+	if err := drivePreflight(ctx, cfg); err != nil {
+		return err
+	}
+
+	var results []runResult
+	for _, id := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, p.processMessage(ctx, id))
+	}
+	if len(results) > 0 {
+		logRunSummary(results)
+	}
+	if failedCount(results) > 0 {
+		return errors.New("some messages failed")
+	}
+	return nil
+}
+
+// parseSince accepts a date (YYYY-MM-DD, local time) or a duration counted back from now.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("%q is neither a date nor a duration", v)
+	}
+	return now.Add(-d), nil
+}
+
+func cmdFetch(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("fetch")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	prof, err := loadProfile(cfg, *profileName)
+	if err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	srv, err := ensureGmailService(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("obtain gmail service: %w", err)
+	}
+	p := &pipeline{cfg: cfg, repo: gmail.NewMessageRepository(srv), state: store, profile: prof}
+
+	return forEachArg(fs.Args(), func(id string) error {
+		rec, err := p.record(id)
+		if err != nil {
+			return err
+		}
+		_, rawPath, err := p.fetch(ctx, rec, message.ID(id))
+		if err != nil {
+			return err
+		}
+		fmt.Println(rawPath)
+		return nil
+	})
+}
+
+func cmdClean(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("clean")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	// 明示的に呼ばれたときは CLEANUP_ENABLED に関わらず実行する
+	c := *cfg
+	c.CleanupEnabled = true
+	p := &pipeline{cfg: &c, state: store}
+
+	return forEachArg(fs.Args(), func(arg string) error {
+		rawPath, err := p.resolveArtifact(arg, state.StageRawSaved, filepath.Join("text", "raw_txt", arg), ".txt")
+		if err != nil {
+			return err
+		}
+		rec, err := p.record(extractMessageIDFromPath(rawPath))
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		cleanPath, err := p.clean(rec, rawPath)
+		if err != nil {
+			return err
+		}
+		fmt.Println(cleanPath)
+		return nil
+	})
+}
+
+func cmdConvert(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("convert")
+	force := fs.Bool("force", false, "reconvert every chunk instead of reusing up-to-date parts")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	// convert は TTS を使わないので、変換に必要な設定だけ確認する
+	if _, err := newTransformer(cfg); err != nil {
+		return err
+	}
+	p := &pipeline{cfg: cfg, state: store, force: singleForce(*force, state.StageConverted)}
+
+	return forEachArg(fs.Args(), func(arg string) error {
+		rawPath, err := p.resolveArtifact(arg, state.StageRawSaved, filepath.Join("text", "raw_txt", arg), ".txt")
+		if err != nil {
+			return err
+		}
+		rec, err := p.record(extractMessageIDFromPath(rawPath))
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		textPath, err := p.clean(rec, rawPath)
+		if err != nil {
+			return err
+		}
+		podcastDir, err := p.convert(ctx, rec, textPath)
+		if err != nil {
+			return err
+		}
+		fmt.Println(podcastDir)
+		return nil
+	})
+}
+
+func cmdSynthesize(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("synthesize")
+	force := fs.Bool("force", false, "resynthesize every part instead of reusing up-to-date audio")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	if err := cfg.RequireOpenAIKey(); err != nil {
+		return err
+	}
+	p := &pipeline{cfg: cfg, state: store, force: singleForce(*force, state.StageSynthesized)}
+
+	return forEachArg(fs.Args(), func(arg string) error {
+		// 単一のテキストファイルなら、そのファイルだけを1パートとして合成する
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			rec, err := p.record(extractMessageIDFromPath(arg))
+			if err != nil {
+				return err
+			}
+			if err := p.useProfile(profiles, *profileName, rec); err != nil {
+				return err
+			}
+			speech, err := p.speech()
+			if err != nil {
+				return err
+			}
+			partPath, err := processSinglePart(ctx, arg, rec.MessageID, speech)
+			if err != nil {
+				return p.fail(rec, state.StageSynthesized, err)
+			}
+			if err := p.complete(rec, state.StageSynthesized, filepath.Dir(partPath)); err != nil {
+				return err
+			}
+			fmt.Println(partPath)
+			return nil
+		}
+
+		podcastDir := arg
+		if info, err := os.Stat(arg); err != nil || !info.IsDir() {
+			podcastDir = filepath.Join("text", "podcast_txt", arg)
+		}
+		rec, err := p.record(filepath.Base(podcastDir))
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		parts, err := p.synthesize(ctx, rec, podcastDir)
+		if err != nil {
+			return err
+		}
+		for _, part := range parts {
+			fmt.Println(part)
+		}
+		return nil
+	})
+}
+
+func cmdTerms(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("terms")
+	asJSON := fs.Bool("json", false, "print dictionary entries to fill in the readings of")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	p := &pipeline{cfg: cfg, state: store}
+
+	return forEachArg(fs.Args(), func(arg string) error {
+		id := arg
+		if fileExists(arg) {
+			id = extractMessageIDFromPath(arg)
+		}
+		rec, err := p.record(id)
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		dict, err := pronunciation.Load(p.profile.Pronunciation)
+		if err != nil {
+			return fmt.Errorf("load pronunciation dictionary: %w", err)
+		}
+		text, source, err := p.spokenMessageText(arg)
+		if err != nil {
+			return err
+		}
+		terms := dict.UnknownTerms(text)
+		log.Printf("[terms] %s: %d unknown term(s) in %s (dictionary %s, %d entries)", arg, len(terms), source, p.profile.Pronunciation, dict.Len())
+
+		if *asJSON {
+			f := pronunciation.File{Entries: make([]pronunciation.Entry, 0, len(terms))}
+			for _, t := range terms {
+				f.Entries = append(f.Entries, pronunciation.Entry{Term: t.Text, WholeWord: true})
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			return enc.Encode(f)
+		}
+		for _, t := range terms {
+			fmt.Printf("%d\t%s\n", t.Count, t.Text)
+		}
+		return nil
+	})
+}
+
+func cmdMerge(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("merge")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	p := &pipeline{cfg: cfg, state: store}
+
+	return forEachArg(fs.Args(), func(id string) error {
+		rec, err := p.record(id)
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		partsDir := filepath.Join("audio", "parts", id)
+		parts := loadManifest(partsDir).outputs(partsDir)
+		mergedPath, err := p.merge(rec, parts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(mergedPath)
+		return nil
+	})
+}
+
+func cmdUpload(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("upload")
+	force := fs.Bool("force", false, "upload again even if the episode was already uploaded")
+	profileName := addProfileFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	profiles, err := loadProfiles(cfg, "")
+	if err != nil {
+		return err
+	}
+	p := &pipeline{cfg: cfg, state: store, force: singleForce(*force, state.StageUploaded)}
+
+	return forEachArg(fs.Args(), func(arg string) error {
+		// 任意の mp3 ファイルはそのままアップロードする（状態は記録しない）
+		if fileExists(arg) {
+			prof, err := config.FindProfile(profiles, *profileName)
+			if err != nil {
+				return err
+			}
+			link, err := uploadToDrive(ctx, arg, prof.DriveFolderID)
+			if err != nil {
+				return err
+			}
+			fmt.Println(link)
+			return nil
+		}
+		mergedPath, err := p.resolveArtifact(arg, state.StageMerged, filepath.Join("audio", "merged", arg), ".mp3")
+		if err != nil {
+			return err
+		}
+		rec, err := p.record(arg)
+		if err != nil {
+			return err
+		}
+		if err := p.useProfile(profiles, *profileName, rec); err != nil {
+			return err
+		}
+		if err := p.upload(ctx, rec, mergedPath); err != nil {
+			return err
+		}
+		fmt.Println(rec.Artifacts[state.StageUploaded])
+		return nil
+	})
+}
+
+func cmdStatus(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if fs.NArg() > 0 {
+		for _, id := range fs.Args() {
+			rec, err := store.Get(id)
+			if err != nil {
+				return err
+			}
+			if rec == nil {
+				fmt.Printf("%s: unknown\n\n", id)
+				continue
+			}
+			printRecord(rec)
+		}
+		return nil
+	}
+
+	recs, err := store.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSTAGE\tATTEMPTS\tUPDATED\tSUBJECT\tERROR")
+	for _, rec := range recs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			rec.MessageID, rec.Status, rec.Stage, rec.Attempts,
+			rec.UpdatedAt.Local().Format("2006-01-02 15:04"), truncate(rec.Subject, 40), truncate(rec.Error, 60))
+	}
+	return w.Flush()
+}
+
+func cmdConfig(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := newFlagSet("config")
+	max := addMaxFlag(fs)
+	attachments := addAttachmentsFlag(fs)
+	thread := addThreadFlag(fs, cfg)
+	sf := addScheduleFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) != "print" {
+		fs.Usage()
+		return errors.New("unknown config subcommand")
+	}
+	// 各コマンドと同じようにフラグを反映してから表示・検証する（不正な値は Validate が報告する）
+	if *max >= 0 {
+		cfg.MaxMessagesPerRun = *max
+	}
+	if *attachments != "" {
+		cfg.AttachmentMode = *attachments
+	}
+	cfg.ThreadMode = *thread
+	sf.apply(cfg)
+
+	out := cfg.Redacted()
+	// プロファイルは継承を解決した実効値を表示する
+	if profiles, err := config.LoadProfiles(cfg); err == nil {
+		out.Profiles = profiles
+	}
+	source := cfg.File
+	if source == "" {
+		source = "none (env and defaults only)"
+	}
+	fmt.Printf("# config file: %s\n", source)
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	enc.Close()
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+func printRecord(rec *state.Record) {
+	fmt.Printf("id:       %s\n", rec.MessageID)
+	fmt.Printf("subject:  %s\n", rec.Subject)
+	if rec.Profile != "" {
+		fmt.Printf("profile:  %s\n", rec.Profile)
+	}
+	fmt.Printf("status:   %s\n", rec.Status)
+	fmt.Printf("attempts: %d\n", rec.Attempts)
+	if rec.Error != "" {
+		fmt.Printf("error:    [%s] %s\n", rec.ErrorAt, rec.Error)
+	}
+	for _, stage := range state.Stages {
+		at, ok := rec.StageTimes[stage]
+		if !ok {
+			fmt.Printf("  %-12s -\n", stage)
+			continue
+		}
+		fmt.Printf("  %-12s %s  %s\n", stage, at.Local().Format(time.DateTime), rec.Artifacts[stage])
+	}
+	fmt.Println()
+}
+
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// resolveArtifact finds the output of stage for arg: arg itself when it is an
+// existing file, else the artifact recorded in the state store, else the first
+// file with extension ext in fallbackDir.
+func (p *pipeline) resolveArtifact(arg string, stage state.Stage, fallbackDir, ext string) (string, error) {
+	if fileExists(arg) {
+		return arg, nil
+	}
+	rec, err := p.state.Get(arg)
+	if err != nil {
+		return "", err
+	}
+	if rec != nil && fileExists(rec.Artifacts[stage]) {
+		return rec.Artifacts[stage], nil
+	}
+	matches, _ := filepath.Glob(filepath.Join(fallbackDir, "*"+ext))
+	for _, m := range matches {
+		if fileExists(m) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no %s output found (run the previous stage first)", arg, stage)
+}
+
+// forEachArg runs fn for every argument, continuing after failures, and
+// returns an error if any of them failed.
+func forEachArg(args []string, fn func(arg string) error) error {
+	failed := 0
+	for _, arg := range args {
+		if err := fn(arg); err != nil {
+			log.Printf("[cli] %s: %v", arg, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(args))
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"gmail-tts-app/internal/config"
+)
+
+// pollSchedule builds the daemon schedule from config. A cron expression takes
+// precedence over the fixed interval.
+func pollSchedule(cfg *config.Config) (cron.Schedule, error) {
+	if cfg.PollCron != "" {
+		sched, err := cron.ParseStandard(cfg.PollCron)
+		if err != nil {
+			return nil, fmt.Errorf("parse cron %q: %w", cfg.PollCron, err)
+		}
+		log.Printf("[daemon] schedule: cron %q", cfg.PollCron)
+		return sched, nil
+	}
+	if cfg.PollInterval <= 0 {
+		return nil, fmt.Errorf("poll interval must be positive: %s", cfg.PollInterval)
+	}
+	log.Printf("[daemon] schedule: every %s", cfg.PollInterval)
+	return cron.Every(cfg.PollInterval), nil
+}
+
+// runDaemon runs fn immediately and then on every tick of sched until ctx is
+// cancelled. A run in progress is allowed to observe the cancellation and
+// return before the daemon exits.
+func runDaemon(ctx context.Context, sched cron.Schedule, fn func(ctx context.Context)) {
+	log.Printf("[daemon] started")
+	for {
+		fn(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		next := sched.Next(time.Now())
+		log.Printf("[daemon] next run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	log.Printf("[daemon] shutting down: %v", context.Cause(ctx))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so an interrupted run never leaves a half-written file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
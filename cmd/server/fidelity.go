@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/infrastructure/fidelity"
+)
+
+// fidelityReportName is written next to the podcast parts.
+const fidelityReportName = "fidelity.json"
+
+// chunkFidelity is the check result of one converted chunk.
+type chunkFidelity struct {
+	Part     int    `json:"part"`
+	File     string `json:"file"`
+	OK       bool   `json:"ok"`
+	Attempts int    `json:"attempts"` // 0 = reused from a previous run
+	fidelity.Result
+}
+
+// fidelityReport collects the chunk results of one conversion.
+type fidelityReport struct {
+	Flagged int             `json:"flagged"`
+	Chunks  []chunkFidelity `json:"chunks"`
+}
+
+func (r *fidelityReport) add(part int, file string, attempts int, res fidelity.Result) {
+	if !res.OK() {
+		r.Flagged++
+	}
+	r.Chunks = append(r.Chunks, chunkFidelity{Part: part, File: file, OK: res.OK(), Attempts: attempts, Result: res})
+}
+
+func (r *fidelityReport) save(dir string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, fidelityReportName), data, 0o644)
+}
+
+func tolerance(f config.FidelityConfig) fidelity.Tolerance {
+	return fidelity.Tolerance{
+		MinLengthRatio:    f.MinLengthRatio,
+		MaxLengthRatio:    f.MaxLengthRatio,
+		MinCoverage:       f.MinCoverage,
+		MaxUnmatched:      f.MaxUnmatched,
+		MaxMissingNumbers: f.MaxMissingNumbers,
+		MaxMissingNames:   f.MaxMissingNames,
+	}
+}
+
+// transformChunk converts chunk as set in conv. When the fidelity check is enabled
+// the spoken output is checked against chunk, and a chunk out of tolerance is
+// converted again up to Retries times; the best attempt is returned with its result.
+func transformChunk(ctx context.Context, conv conversion, prompt, chunk, label string) (string, fidelity.Result, int, error) {
+	fid := conv.fidelity
+	var (
+		best    string
+		bestRes fidelity.Result
+	)
+	attempts := 0
+	for attempts <= fid.Retries {
+		p := prompt
+		if attempts > 0 {
+			p = retryPrompt(prompt, bestRes)
+		}
+		out, spoken, err := conv.convert(ctx, p, chunk)
+		if err != nil {
+			return "", fidelity.Result{}, attempts, err
+		}
+		attempts++
+		if !fid.Enabled {
+			return out, fidelity.Result{}, attempts, nil
+		}
+		res := fidelity.Check(chunk, spoken, tolerance(fid))
+		if attempts == 1 || res.Better(bestRes) {
+			best, bestRes = out, res
+		}
+		if res.OK() {
+			break
+		}
+		log.Printf("[fidelity] %s attempt %d out of tolerance: %s", label, attempts, strings.Join(res.Issues, "; "))
+	}
+	return best, bestRes, attempts, nil
+}
+
+// retryPrompt reminds the model of what the previous attempt lost.
+func retryPrompt(prompt string, prev fidelity.Result) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\n【再変換の注意】前回の出力は入力の内容を十分に保持していませんでした。入力のすべての文を省略・追加せずに変換してください。")
+	if len(prev.MissingNumbers) > 0 {
+		fmt.Fprintf(&b, "\n欠落した数値: %s", strings.Join(prev.MissingNumbers, ", "))
+	}
+	if len(prev.MissingNames) > 0 {
+		fmt.Fprintf(&b, "\n欠落した固有名詞: %s", strings.Join(prev.MissingNames, ", "))
+	}
+	for _, d := range prev.Dropped {
+		fmt.Fprintf(&b, "\n欠落した文: %s", d)
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestFile is kept in every stage output directory and maps each part to the
+// hash of the inputs it was produced from, so reruns can reuse valid outputs.
+const manifestFile = ".manifest.json"
+
+type stageManifest struct {
+	Parts map[int]manifestEntry `json:"parts"`
+}
+
+type manifestEntry struct {
+	InputHash string `json:"input_hash"`
+	Output    string `json:"output"` // file name within the directory
+}
+
+// loadManifest reads dir's manifest. A missing or broken manifest yields an
+// empty one, which simply means nothing is reused.
+func loadManifest(dir string) *stageManifest {
+	m := &stageManifest{Parts: make(map[int]manifestEntry)}
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return m
+	}
+	if err := json.Unmarshal(data, m); err != nil || m.Parts == nil {
+		return &stageManifest{Parts: make(map[int]manifestEntry)}
+	}
+	return m
+}
+
+func (m *stageManifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestFile), data, 0o644)
+}
+
+// reusable reports whether part was produced from inputHash and its output
+// file is still present and non-empty.
+func (m *stageManifest) reusable(dir string, part int, output, inputHash string) bool {
+	e, ok := m.Parts[part]
+	if !ok || e.InputHash != inputHash || e.Output != output {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, output))
+	return err == nil && !info.IsDir() && info.Size() > 0
+}
+
+func (m *stageManifest) record(part int, output, inputHash string) {
+	m.Parts[part] = manifestEntry{InputHash: inputHash, Output: output}
+}
+
+// outputs returns the recorded output paths in part order.
+func (m *stageManifest) outputs(dir string) []string {
+	parts := make([]int, 0, len(m.Parts))
+	for part := range m.Parts {
+		parts = append(parts, part)
+	}
+	sort.Ints(parts)
+	paths := make([]string, 0, len(parts))
+	for _, part := range parts {
+		paths = append(paths, filepath.Join(dir, m.Parts[part].Output))
+	}
+	return paths
+}
+
+// prune drops manifest entries beyond the current part count and removes files
+// with extension ext in dir that the current run did not produce.
+func (m *stageManifest) prune(dir string, parts int, ext string) error {
+	keep := make(map[string]bool)
+	for part, e := range m.Parts {
+		if part > parts {
+			delete(m.Parts, part)
+			continue
+		}
+		keep[e.Output] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ext || keep[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contentHash returns a hex SHA-256 over the given inputs.
+func contentHash(inputs ...string) string {
+	h := sha256.New()
+	for _, in := range inputs {
+		h.Write([]byte(in))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
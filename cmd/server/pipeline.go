@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/domain/state"
+	"gmail-tts-app/internal/infrastructure/gmail"
+	"gmail-tts-app/internal/infrastructure/pronunciation"
+	"gmail-tts-app/internal/infrastructure/textclean"
+	"gmail-tts-app/internal/workpool"
+)
+
+// pipeline bundles what the raw→podcast→TTS→Drive flow needs per message.
+type pipeline struct {
+	cfg   *config.Config
+	repo  message.Repository
+	state state.Store
+	// force lists stages whose existing outputs must be regenerated instead of reused.
+	force map[state.Stage]bool
+	// postActions are applied to the source message when repo supports them (Gmail).
+	postActions gmail.PostActions
+	// profile supplies the prompt, voice, cleanup rules, attachment mode, episode name and Drive folder.
+	profile config.Profile
+}
+
+// withProfile returns a copy of p that produces episodes for prof.
+func (p *pipeline) withProfile(prof config.Profile) *pipeline {
+	c := *p
+	c.profile = prof
+	return &c
+}
+
+// ttsConfig is the configured voice with the overrides of the profile applied.
+func (p *pipeline) ttsConfig() config.TTSConfig {
+	return p.profile.ApplyTTS(p.cfg.TTS)
+}
+
+// speech builds the synthesizer of the profile: its voices, dialogue mode and
+// pronunciation dictionary, with the configured concurrency and request rate.
+func (p *pipeline) speech() (*speechSynth, error) {
+	dict, err := pronunciation.Load(p.profile.Pronunciation)
+	if err != nil {
+		return nil, fmt.Errorf("load pronunciation dictionary: %w", err)
+	}
+	return newSpeechSynth(p.cfg.OpenAIAPIKey, p.ttsConfig(), p.profile.Dialogue(), dict,
+		p.cfg.TTSConcurrency, workpool.NewLimiter(p.cfg.TTSRequestsPerMinute)), nil
+}
+
+// postActioner is implemented by repositories that can mark the source message
+// once its episode is done.
+type postActioner interface {
+	ApplyPostActions(ctx context.Context, id message.ID, a gmail.PostActions) error
+}
+
+// parseForceStages parses the -force flag: a comma separated list of
+// convert, synthesize, upload, or "all".
+func parseForceStages(v string) (map[state.Stage]bool, error) {
+	force := make(map[state.Stage]bool)
+	for _, name := range strings.Split(v, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "all":
+			force[state.StageConverted] = true
+			force[state.StageSynthesized] = true
+			force[state.StageUploaded] = true
+		case "convert":
+			force[state.StageConverted] = true
+		case "synthesize", "tts":
+			force[state.StageSynthesized] = true
+		case "upload":
+			force[state.StageUploaded] = true
+		default:
+			return nil, fmt.Errorf("unknown stage %q (want convert, synthesize, upload or all)", name)
+		}
+	}
+	return force, nil
+}
+
+// pendingMessageIDs filters out messages already done in the state store from ids
+// (newest first, as returned by Gmail), and returns the rest oldest first.
+// Callers cap the head of the result so the oldest messages are never skipped.
+func (p *pipeline) pendingMessageIDs(ids []message.ID) ([]message.ID, error) {
+	var pending []message.ID
+	for i := len(ids) - 1; i >= 0; i-- {
+		rec, err := p.state.Get(string(ids[i]))
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil && rec.Status == state.StatusDone {
+			continue
+		}
+		pending = append(pending, ids[i])
+	}
+	return pending, nil
+}
+
+// queue records the ids that have no state yet as queued for the profile, so
+// they are picked up by retryIDs even after the sync position moved past them.
+func (p *pipeline) queue(ids []message.ID) error {
+	for _, id := range ids {
+		rec, err := p.state.Get(string(id))
+		if err != nil {
+			return err
+		}
+		if rec != nil {
+			continue
+		}
+		rec = state.NewRecord(string(id))
+		rec.Status = state.StatusQueued
+		rec.Profile = p.profile.Name
+		if err := p.state.Put(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryIDs returns the unfinished Gmail messages (threads in thread mode) of
+// the profile that have attempts left, oldest first. Messages that failed
+// MaxAttempts times are left to an explicit "run <id>".
+func (p *pipeline) retryIDs() ([]message.ID, error) {
+	recs, err := p.state.List()
+	if err != nil {
+		return nil, err
+	}
+	var ids []message.ID
+	given := 0
+	for _, rec := range recs {
+		if rec.Profile != p.profile.Name || rec.Status == state.StatusDone {
+			continue
+		}
+		id := message.ID(rec.MessageID)
+		if !p.syncedID(id) {
+			continue
+		}
+		if !rec.Retryable(p.cfg.MaxAttempts) {
+			given++
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if given > 0 {
+		log.Printf("[state] %d message(s) failed %d times and are no longer retried (see status)", given, p.cfg.MaxAttempts)
+	}
+	return ids, nil
+}
+
+// syncedID reports whether id is one runOnce lists: a Gmail message ID, or a
+// thread ID in thread mode. Local file, mbox and IMAP IDs are not.
+func (p *pipeline) syncedID(id message.ID) bool {
+	if threadID, ok := message.IsThread(id); ok {
+		return p.cfg.ThreadMode && gmail.IsMessageID(threadID)
+	}
+	return !p.cfg.ThreadMode && gmail.IsMessageID(string(id))
+}
+
+// record loads the state record of id, creating an empty one when unknown.
+func (p *pipeline) record(id string) (*state.Record, error) {
+	rec, err := p.state.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+	if rec == nil {
+		rec = state.NewRecord(id)
+	}
+	return rec, nil
+}
+
+// complete records stage as completed in rec and persists it.
+func (p *pipeline) complete(rec *state.Record, stage state.Stage, artifact string) error {
+	rec.Complete(stage, artifact)
+	if err := p.state.Put(rec); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
+}
+
+// fail records the failure of stage in rec, persists it and returns err.
+func (p *pipeline) fail(rec *state.Record, stage state.Stage, err error) error {
+	rec.Fail(stage, err)
+	if perr := p.state.Put(rec); perr != nil {
+		log.Printf("[state] failed to record failure of %s: %v", rec.MessageID, perr)
+	}
+	return &stageError{Stage: stage, Err: err}
+}
+
+// stageError is returned by the stage methods and tells which stage failed.
+type stageError struct {
+	Stage state.Stage
+	Err   error
+}
+
+func (e *stageError) Error() string { return fmt.Sprintf("%s: %v", e.Stage, e.Err) }
+func (e *stageError) Unwrap() error { return e.Err }
+
+// fetch retrieves the message and saves its body under text/raw_txt/{id}/.
+func (p *pipeline) fetch(ctx context.Context, rec *state.Record, id message.ID) (*message.EmailMessage, string, error) {
+	// 4.5) メッセージ本文をテキストファイルとして保存
+	msg, err := p.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, "", p.fail(rec, state.StageFetched, fmt.Errorf("get message: %w", err))
+	}
+	rec.Subject = msg.Subject
+	rec.From = msg.From
+	rec.Date = msg.Date
+	rec.Profile = p.profile.Name
+	rec.MessageIDHeader = msg.MessageIDHeader
+	log.Printf("[flow] retrieved message: subject=%s from=%s date=%s links=%d", msg.Subject, msg.From, msg.Date.Format(time.RFC3339), len(msg.Links))
+	if err := p.complete(rec, state.StageFetched, ""); err != nil {
+		return nil, "", p.fail(rec, state.StageFetched, err)
+	}
+
+	mode, err := parseAttachmentMode(p.profile.AttachmentMode)
+	if err != nil {
+		return nil, "", p.fail(rec, state.StageRawSaved, err)
+	}
+	if mode != attachSkip && len(msg.Attachments) > 0 {
+		log.Printf("[flow] narrating %d attachment(s) (%s)", len(msg.Attachments), mode)
+	}
+	msg.Body = withAttachments(msg, mode)
+	savedPath, err := saveMessageAsText(msg)
+	if err != nil {
+		return nil, "", p.fail(rec, state.StageRawSaved, fmt.Errorf("save message as text: %w", err))
+	}
+	if err := p.complete(rec, state.StageRawSaved, savedPath); err != nil {
+		return nil, "", p.fail(rec, state.StageRawSaved, err)
+	}
+	return msg, savedPath, nil
+}
+
+// clean strips signatures, footers and rule matches from the raw text and
+// returns the path convert should read. Cleaning is cheap, so it always reruns
+// and picks up edited rules. With cleanup disabled the raw text is used as is.
+func (p *pipeline) clean(rec *state.Record, rawPath string) (string, error) {
+	if !p.cfg.CleanupEnabled {
+		return rawPath, nil
+	}
+	cleaner, err := newCleaner(p.cfg, p.profile.CleanupRules)
+	if err != nil {
+		return "", p.fail(rec, state.StageCleaned, err)
+	}
+	cleanPath, _, err := cleanRawText(rawPath, cleaner, textclean.Source{From: rec.From, Subject: rec.Subject})
+	if err != nil {
+		return "", p.fail(rec, state.StageCleaned, err)
+	}
+	if err := p.complete(rec, state.StageCleaned, cleanPath); err != nil {
+		return "", p.fail(rec, state.StageCleaned, err)
+	}
+	return cleanPath, nil
+}
+
+// convert turns the (cleaned) raw text file into podcast parts under text/podcast_txt/{id}/.
+func (p *pipeline) convert(ctx context.Context, rec *state.Record, rawPath string) (string, error) {
+	// 4.6) テキストファイルをポッドキャスト用に変換（変換済みのチャンクは再利用）
+	conv, err := newConversion(p.cfg, p.profile)
+	if err != nil {
+		return "", p.fail(rec, state.StageConverted, err)
+	}
+	if err := convertToPodcast(ctx, rawPath, conv, p.force[state.StageConverted]); err != nil {
+		return "", p.fail(rec, state.StageConverted, fmt.Errorf("convert to podcast: %w", err))
+	}
+	podcastDir := filepath.Join("text", "podcast_txt", rec.MessageID)
+	if err := p.complete(rec, state.StageConverted, podcastDir); err != nil {
+		return "", p.fail(rec, state.StageConverted, err)
+	}
+	return podcastDir, nil
+}
+
+// synthesize generates audio parts from the podcast parts in podcastDir.
+func (p *pipeline) synthesize(ctx context.Context, rec *state.Record, podcastDir string) ([]string, error) {
+	// 5) TTS処理：podcast_txt → audio（合成済みのパートは再利用）
+	log.Printf("[flow] processing TTS from podcast files")
+	speech, err := p.speech()
+	if err != nil {
+		return nil, p.fail(rec, state.StageSynthesized, err)
+	}
+	parts, err := synthesizePodcastParts(ctx, podcastDir, rec.MessageID, speech, p.force[state.StageSynthesized])
+	if err != nil {
+		return nil, p.fail(rec, state.StageSynthesized, fmt.Errorf("synthesize parts: %w", err))
+	}
+	if err := p.complete(rec, state.StageSynthesized, filepath.Join("audio", "parts", rec.MessageID)); err != nil {
+		return nil, p.fail(rec, state.StageSynthesized, err)
+	}
+	return parts, nil
+}
+
+// merge concatenates the audio parts into the episode file.
+func (p *pipeline) merge(rec *state.Record, parts []string) (string, error) {
+	name, err := p.profile.EpisodeName(sanitizeFilename(rec.Subject), rec.MessageID, rec.Date)
+	if err != nil {
+		return "", p.fail(rec, state.StageMerged, err)
+	}
+	mergedPath, err := mergeAudioParts(parts, rec.MessageID, name)
+	if err != nil {
+		return "", p.fail(rec, state.StageMerged, fmt.Errorf("merge parts: %w", err))
+	}
+	if err := p.complete(rec, state.StageMerged, mergedPath); err != nil {
+		return "", p.fail(rec, state.StageMerged, err)
+	}
+	return mergedPath, nil
+}
+
+// upload sends the episode to Drive unless it was already uploaded.
+func (p *pipeline) upload(ctx context.Context, rec *state.Record, mergedPath string) error {
+	// 6) Google Drive へアップロード（アップロード済みなら重複させない）
+	if rec.Completed(state.StageUploaded) && !p.force[state.StageUploaded] {
+		log.Printf("[drive] already uploaded: %s", rec.Artifacts[state.StageUploaded])
+		return nil
+	}
+	log.Printf("[drive] uploading to Drive folder=%s", p.profile.DriveFolderID)
+	link, err := uploadToDrive(ctx, mergedPath, p.profile.DriveFolderID)
+	if err != nil {
+		return p.fail(rec, state.StageUploaded, fmt.Errorf("drive upload: %w", err))
+	}
+	if err := p.complete(rec, state.StageUploaded, link); err != nil {
+		return p.fail(rec, state.StageUploaded, err)
+	}
+	return nil
+}
+
+// postProcess applies the post-actions (label, mark read, archive) to the
+// source message. It runs after the upload so a failed run leaves the inbox as is.
+func (p *pipeline) postProcess(ctx context.Context, rec *state.Record, id message.ID) error {
+	pa, ok := p.repo.(postActioner)
+	if !ok || p.postActions.Empty() || rec.Completed(state.StagePostProcessed) {
+		return nil
+	}
+	if err := pa.ApplyPostActions(ctx, id, p.postActions); err != nil {
+		return p.fail(rec, state.StagePostProcessed, fmt.Errorf("post-actions: %w", err))
+	}
+	if err := p.complete(rec, state.StagePostProcessed, p.postActions.String()); err != nil {
+		return p.fail(rec, state.StagePostProcessed, err)
+	}
+	return nil
+}
+
+// processMessage runs the whole pipeline for a single message, recording the
+// outcome of every stage in the state store.
+func (p *pipeline) processMessage(ctx context.Context, id message.ID) runResult {
+	res := runResult{ID: id, Started: time.Now()}
+	msgID := string(id)
+
+	rec, err := p.record(msgID)
+	if err != nil {
+		return res.fail(state.StageFetched, err)
+	}
+	rec.Begin()
+	if err := p.state.Put(rec); err != nil {
+		return res.fail(state.StageFetched, fmt.Errorf("save state: %w", err))
+	}
+	failed := func(err error) runResult {
+		res.Subject = rec.Subject
+		var se *stageError
+		if errors.As(err, &se) {
+			return res.fail(se.Stage, se.Err)
+		}
+		return res.fail(rec.Stage, err)
+	}
+
+	msg, rawPath, err := p.fetch(ctx, rec, id)
+	if err != nil {
+		return failed(err)
+	}
+	res.Subject = msg.Subject
+
+	textPath, err := p.clean(rec, rawPath)
+	if err != nil {
+		return failed(err)
+	}
+	podcastDir, err := p.convert(ctx, rec, textPath)
+	if err != nil {
+		return failed(err)
+	}
+	parts, err := p.synthesize(ctx, rec, podcastDir)
+	if err != nil {
+		return failed(err)
+	}
+	mergedAudioPath, err := p.merge(rec, parts)
+	if err != nil {
+		return failed(err)
+	}
+	res.AudioPath = mergedAudioPath
+
+	if p.cfg.DriveUploadEnabled {
+		if err := p.upload(ctx, rec, mergedAudioPath); err != nil {
+			return failed(err)
+		}
+	}
+	if err := p.postProcess(ctx, rec, id); err != nil {
+		return failed(err)
+	}
+
+	// 7) 処理完了を記録
+	rec.Done()
+	if err := p.state.Put(rec); err != nil {
+		return res.fail(state.StageUploaded, fmt.Errorf("save state: %w", err))
+	}
+	log.Printf("[flow] completed for %s", msgID)
+	return res.ok()
+}
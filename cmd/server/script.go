@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gmail-tts-app/internal/chunker"
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/domain/script"
+	"gmail-tts-app/internal/domain/transform"
+)
+
+// scriptSchemaName names the script schema in structured output requests.
+const scriptSchemaName = "podcast_script"
+
+// scriptInstructions are added to the prompt when the parts are JSON scripts.
+const scriptInstructions = `
+
+【出力形式】
+出力は指定の JSON スキーマに従う台本とします。
+・version は 1。title は空文字で構いません。
+・本文の区切り（見出し・話題）ごとに sections を分け、見出しがあれば title に入れてください。新しい大きな話題（記事・章）の始まりは chapter を true にします。
+・segments は読み上げる単位で、text に読み上げる文章を入れます。見出しや記号だけの segment は作らないでください。
+・voice は空文字にしてください。話題の切り替わりなど間を置きたい segment の pause_after_ms に 500〜1500 を、それ以外は 0 を入れてください。`
+
+// dialogueScriptInstructions tell the model where the speakers go in a JSON script.
+const dialogueScriptInstructions = `
+・話者タグは text に書かず、speaker に "HOST" または "GUEST" を入れてください。`
+
+// narrationScriptInstructions leave the speaker empty.
+const narrationScriptInstructions = `
+・speaker は空文字にしてください。`
+
+// conversion is how convertToPodcast turns the text chunks into podcast parts.
+type conversion struct {
+	tr       transform.Transformer
+	limits   chunker.Limits // input size of one conversion request
+	settings string         // identifies tr and the part format in the conversion manifest
+	prompt   string         // prompt file
+	json     bool           // versioned JSON scripts instead of free-form text parts
+	dialogue bool
+	speakers []string
+	fidelity config.FidelityConfig
+}
+
+// newConversion builds the conversion of a profile.
+func newConversion(cfg *config.Config, prof config.Profile) (conversion, error) {
+	tr, err := newTransformer(cfg)
+	if err != nil {
+		return conversion{}, err
+	}
+	return conversion{
+		tr:       tr,
+		limits:   conversionLimits(cfg.Transform),
+		settings: transformSettings(cfg) + "|" + cfg.ScriptFormat,
+		prompt:   prof.Prompt,
+		json:     cfg.ScriptFormat == "json",
+		dialogue: prof.Dialogue(),
+		speakers: speakers(prof.ApplyTTS(cfg.TTS)),
+		fidelity: cfg.Fidelity,
+	}, nil
+}
+
+// conversionLimits bounds the chunks sent to the LLM: 8KB, and at most half of
+// max_tokens so the converted text (about as long as its input) fits the reply.
+func conversionLimits(t config.TransformConfig) chunker.Limits {
+	l := chunker.Limits{MaxBytes: 8 * 1024}
+	if t.Provider != "passthrough" && t.MaxTokens > 0 {
+		l.MaxTokens = t.MaxTokens / 2
+	}
+	return l
+}
+
+// ext is the extension of the part files.
+func (c conversion) ext() string {
+	if c.json {
+		return ".json"
+	}
+	return ".txt"
+}
+
+// convert converts one chunk and returns the part file content and the text it speaks.
+func (c conversion) convert(ctx context.Context, prompt, chunk string) (string, string, error) {
+	if !c.json {
+		out, err := c.tr.Transform(ctx, prompt, chunk)
+		return out, out, err
+	}
+	var sc *script.Script
+	if st, ok := c.tr.(transform.SchemaTransformer); ok {
+		instructions := scriptInstructions + narrationScriptInstructions
+		if c.dialogue {
+			instructions = scriptInstructions + dialogueScriptInstructions
+		}
+		out, err := st.TransformJSON(ctx, prompt+instructions, chunk, scriptSchemaName, script.Schema)
+		if err != nil {
+			return "", "", err
+		}
+		if sc, err = script.Parse([]byte(out)); err != nil {
+			return "", "", fmt.Errorf("invalid script from model: %w", err)
+		}
+	} else {
+		// 構造化出力に対応しない変換（passthrough）は、出力テキストをそのまま台本にする
+		out, err := c.tr.Transform(ctx, prompt, chunk)
+		if err != nil {
+			return "", "", err
+		}
+		sc = textScript(out, c.dialogue, c.speakers)
+		if err := sc.Validate(); err != nil {
+			return "", "", err
+		}
+	}
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), sc.Text(), nil
+}
+
+// textScript is the degenerate script of a free-form text part.
+func textScript(text string, dialogueMode bool, speakers []string) *script.Script {
+	if dialogueMode {
+		return script.FromDialogue(text, speakers...)
+	}
+	return script.FromText(text)
+}
+
+// loadPart reads a podcast part as a script. JSON scripts are validated,
+// including their voices; text parts (.txt) become the degenerate script.
+func loadPart(path string, dialogueMode bool, speakers []string) (*script.Script, error) {
+	if filepath.Ext(path) == ".json" {
+		sc, err := script.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, sec := range sc.Sections {
+			for _, seg := range sec.Segments {
+				if seg.Voice != "" && !config.IsTTSVoice(seg.Voice) {
+					return nil, fmt.Errorf("%s: unknown voice %q", path, seg.Voice)
+				}
+			}
+		}
+		return sc, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc := textScript(string(data), dialogueMode, speakers)
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// spokenText returns the text a part file speaks (for checking reused parts).
+func spokenText(path string) (string, error) {
+	if filepath.Ext(path) == ".json" {
+		sc, err := script.Load(path)
+		if err != nil {
+			return "", err
+		}
+		return sc.Text(), nil
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// speakers returns the dialogue speakers that have a voice, sorted.
+func speakers(t config.TTSConfig) []string {
+	out := make([]string, 0, len(t.Voices))
+	for s := range t.Voices {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/infrastructure/gmail"
+	"gmail-tts-app/internal/infrastructure/pubsub"
+)
+
+// watchRenewMargin is how long before expiry the Gmail watch is renewed.
+// Watches expire after 7 days; Google recommends renewing daily.
+const watchRenewMargin = 24 * time.Hour
+
+// runPushServer serves the Pub/Sub push endpoint and runs the pipeline whenever
+// Gmail reports a mailbox change, until ctx is cancelled or the server fails.
+func runPushServer(ctx context.Context, p *pipeline, msgRepo *gmail.MessageRepository, profiles []config.Profile) error {
+	cfg := p.cfg
+	mailbox, err := msgRepo.EmailAddress(ctx)
+	if err != nil {
+		return err
+	}
+	// サーバが起動できなかったときに同期ループと watch の更新も止めるため
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 通知は履歴差分の同期をトリガするだけなので、処理中に届いた分は1回にまとめる
+	trigger := make(chan struct{}, 1)
+	notify := func(n pubsub.GmailNotification) {
+		if !strings.EqualFold(n.EmailAddress, mailbox) {
+			// 同期対象は認証済みのメールボックスのみなので、同期自体は無害
+			log.Printf("[push] notification for %s does not match mailbox %s. syncing anyway", n.EmailAddress, mailbox)
+		}
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 起動時に一度同期して、停止中に届いたメールを拾う
+		runProfiles(ctx, p, msgRepo, profiles)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-trigger:
+				runProfiles(ctx, p, msgRepo, profiles)
+			}
+		}
+	}()
+
+	if cfg.GmailPubSubTopic != "" {
+		go renewWatch(ctx, msgRepo, cfg.GmailPubSubTopic)
+	} else {
+		log.Printf("[push] GMAIL_PUBSUB_TOPIC is not set. skipping Users.Watch (local push testing only)")
+	}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/healthz", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post("/pubsub/gmail", pubsub.NewGmailPushHandler(cfg.PushVerificationToken, notify))
+
+	go func() {
+		<-ctx.Done()
+		log.Printf("[push] shutting down http server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = app.ShutdownWithContext(shutdownCtx)
+	}()
+
+	log.Printf("[push] listening on %s (POST /pubsub/gmail)", cfg.PushAddr)
+	if err := app.Listen(cfg.PushAddr); err != nil {
+		// ポート使用中などで待ち受けできない。シグナルを待たずに終了する
+		cancel()
+		<-done
+		return fmt.Errorf("push server on %s: %w", cfg.PushAddr, err)
+	}
+	<-done
+	return nil
+}
+
+// renewWatch registers the Gmail watch and renews it before it expires.
+func renewWatch(ctx context.Context, msgRepo *gmail.MessageRepository, topic string) {
+	for {
+		wait := time.Hour // 失敗時のリトライ間隔
+		historyID, expiration, err := msgRepo.Watch(ctx, topic)
+		if err != nil {
+			log.Printf("[push] watch failed: %v", err)
+		} else {
+			log.Printf("[push] watching INBOX via %s (historyId=%d, expires=%s)", topic, historyID, expiration.Format(time.RFC3339))
+			if d := time.Until(expiration) - watchRenewMargin; d > wait {
+				wait = d
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
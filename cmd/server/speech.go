@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gmail-tts-app/internal/chunker"
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/domain/script"
+	"gmail-tts-app/internal/infrastructure/mp3"
+	"gmail-tts-app/internal/infrastructure/pronunciation"
+	openaitts "gmail-tts-app/internal/infrastructure/tts/openai"
+	"gmail-tts-app/internal/workpool"
+)
+
+// speechSynth turns podcast parts into audio, segment by segment with the
+// voice of each segment (its speaker's in dialogue mode).
+type speechSynth struct {
+	apiKey   string
+	cfg      config.TTSConfig
+	dialogue bool
+	speakers []string
+	dict     *pronunciation.Dictionary // readings applied to the text before synthesis
+	workers  int                       // parts synthesized at once
+	limiter  *workpool.Limiter         // speech requests per minute, shared by the workers
+
+	mu     sync.Mutex
+	synths map[string]*openaitts.Synthesizer // by voice, created on first use
+}
+
+func newSpeechSynth(apiKey string, cfg config.TTSConfig, dialogueMode bool, dict *pronunciation.Dictionary, workers int, limiter *workpool.Limiter) *speechSynth {
+	return &speechSynth{
+		apiKey:   apiKey,
+		cfg:      cfg,
+		dialogue: dialogueMode,
+		speakers: speakers(cfg),
+		dict:     dict,
+		workers:  workers,
+		limiter:  limiter,
+		synths:   map[string]*openaitts.Synthesizer{},
+	}
+}
+
+// settings identifies the voice settings for the parts manifest.
+func (s *speechSynth) settings() string {
+	t := s.cfg
+	v := fmt.Sprintf("%s|%s|%g|%s|%v", t.Model, t.Voice, t.Speed, t.ResponseFormat, t.Voices) // map はキー順に出力される
+	if s.dialogue {
+		v += "|dialogue"
+	}
+	if fp := s.dict.Fingerprint(); fp != "" {
+		v += "|dict=" + fp
+	}
+	return v
+}
+
+// chapterMark is the start of a chapter within an audio file.
+type chapterMark struct {
+	Title    string `json:"title"`
+	OffsetMs int64  `json:"offset_ms"`
+}
+
+// synthesizePart reads the part file (JSON script or text) and returns its
+// audio and the chapters that start in it.
+func (s *speechSynth) synthesizePart(ctx context.Context, path string) ([]byte, []chapterMark, error) {
+	sc, err := loadPart(path, s.dialogue, s.speakers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.synthesize(ctx, sc)
+}
+
+// synthesize speaks the segments of sc in order, with the requested pauses
+// between them. Pauses and chapter offsets need mp3 output; with other
+// formats pauses are skipped and no chapters are returned.
+func (s *speechSynth) synthesize(ctx context.Context, sc *script.Script) ([]byte, []chapterMark, error) {
+	isMP3 := strings.EqualFold(s.cfg.ResponseFormat, "mp3")
+	total := 0
+	for _, sec := range sc.Sections {
+		total += len(sec.Segments)
+	}
+
+	var (
+		data     []byte
+		chapters []chapterMark
+		n        int
+		skipped  int
+	)
+	for _, sec := range sc.Sections {
+		if sec.Chapter && isMP3 {
+			chapters = append(chapters, chapterMark{Title: sec.Title, OffsetMs: mp3.Duration(data).Milliseconds()})
+		}
+		for _, seg := range sec.Segments {
+			n++
+			voice := s.voiceOf(seg)
+			if total > 1 {
+				log.Printf("[tts] segment %d/%d: speaker=%q voice=%s (%d chars)", n, total, seg.Speaker, voice, len([]rune(seg.Text)))
+			}
+			// 読みの辞書を当ててから、1リクエストの上限を超える長いセグメントは文の切れ目で分割する
+			for _, piece := range chunker.Split(s.dict.Apply(seg.Text), openaitts.InputLimits) {
+				audio, err := s.speak(ctx, voice, piece)
+				if err != nil {
+					return nil, nil, fmt.Errorf("segment %d: %w", n, err)
+				}
+				data = append(data, audio...)
+			}
+			if seg.PauseAfterMs == 0 {
+				continue
+			}
+			if !isMP3 {
+				skipped++
+				continue
+			}
+			silence, err := mp3.Silence(data, time.Duration(seg.PauseAfterMs)*time.Millisecond)
+			if err != nil {
+				return nil, nil, fmt.Errorf("segment %d pause: %w", n, err)
+			}
+			data = append(data, silence...)
+		}
+	}
+	if skipped > 0 {
+		log.Printf("[tts] %d pause(s) skipped: pauses need response_format mp3", skipped)
+	}
+	return data, chapters, nil
+}
+
+// voiceOf returns the voice of a segment: its own, its speaker's, or the narrator's.
+func (s *speechSynth) voiceOf(seg script.Segment) string {
+	switch {
+	case seg.Voice != "":
+		return seg.Voice
+	case seg.Speaker != "":
+		return s.cfg.VoiceFor(seg.Speaker)
+	default:
+		return s.cfg.Voice
+	}
+}
+
+func (s *speechSynth) speak(ctx context.Context, voice, text string) ([]byte, error) {
+	synth, err := s.synthFor(voice)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	ttsCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	audio, err := synth.Synthesize(ttsCtx, text)
+	if err != nil {
+		return nil, err
+	}
+	return audio.Data, nil
+}
+
+// synthFor returns the synthesizer of voice. Parts are synthesized concurrently.
+func (s *speechSynth) synthFor(voice string) (*openaitts.Synthesizer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if synth, ok := s.synths[voice]; ok {
+		return synth, nil
+	}
+	cfg := s.cfg
+	cfg.Voice = voice
+	synth, err := openaitts.NewSynthesizerWithConfig(s.apiKey, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create synthesizer: %w", err)
+	}
+	s.synths[voice] = synth
+	return synth, nil
+}
+
+// chaptersPath is the chapter list stored next to an audio file.
+func chaptersPath(audioPath string) string {
+	return strings.TrimSuffix(audioPath, ".mp3") + ".chapters.json"
+}
+
+// writeChapters stores the chapters of an audio file next to it, or removes a
+// stale list when there are none.
+func writeChapters(audioPath string, chapters []chapterMark) error {
+	path := chaptersPath(audioPath)
+	if len(chapters) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(chapters, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+// readChapters returns the chapters stored next to an audio file, if any.
+func readChapters(audioPath string) ([]chapterMark, error) {
+	data, err := os.ReadFile(chaptersPath(audioPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var chapters []chapterMark
+	if err := json.Unmarshal(data, &chapters); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", chaptersPath(audioPath), err)
+	}
+	return chapters, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gmail-tts-app/internal/domain/state"
+)
+
+// spokenMessageText returns the text of a message as the TTS will get it: the
+// podcast parts when converted, else the cleaned or raw text. arg is a message
+// ID or a text / part file.
+func (p *pipeline) spokenMessageText(arg string) (string, string, error) {
+	if fileExists(arg) {
+		text, err := spokenText(arg)
+		return text, arg, err
+	}
+	podcastDir := filepath.Join("text", "podcast_txt", arg)
+	if files, err := getPodcastFilesInOrder(podcastDir); err == nil && len(files) > 0 {
+		var texts []string
+		for _, f := range files {
+			text, err := spokenText(f)
+			if err != nil {
+				return "", "", err
+			}
+			texts = append(texts, text)
+		}
+		return strings.Join(texts, "\n"), podcastDir, nil
+	}
+	for _, c := range []struct {
+		stage state.Stage
+		dir   string
+	}{
+		{state.StageCleaned, filepath.Join("text", "clean_txt", arg)},
+		{state.StageRawSaved, filepath.Join("text", "raw_txt", arg)},
+	} {
+		path, err := p.resolveArtifact(arg, c.stage, c.dir, ".txt")
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		return string(data), path, err
+	}
+	return "", "", fmt.Errorf("%s: no text found (fetch the message first)", arg)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"gmail-tts-app/internal/config"
+	"gmail-tts-app/internal/domain/transform"
+	"gmail-tts-app/internal/infrastructure/transform/openai"
+	"gmail-tts-app/internal/infrastructure/transform/passthrough"
+)
+
+// newTransformer builds the text transformer selected by transform.provider.
+func newTransformer(cfg *config.Config) (transform.Transformer, error) {
+	t := cfg.Transform
+	switch t.Provider {
+	case "passthrough":
+		return passthrough.New(), nil
+	case "openai", "":
+		key := t.APIKey
+		if key == "" && (t.BaseURL == "" || t.BaseURL == openai.DefaultBaseURL) {
+			// OpenAI 本体なら TTS と同じキーを使う
+			if err := cfg.RequireOpenAIKey(); err != nil {
+				return nil, err
+			}
+			key = cfg.OpenAIAPIKey
+		}
+		return openai.NewTransformer(openai.Config{
+			APIKey:      key,
+			BaseURL:     t.BaseURL,
+			Model:       t.Model,
+			Temperature: t.Temperature,
+			MaxTokens:   t.MaxTokens,
+			Timeout:     t.Timeout,
+		})
+	default:
+		return nil, fmt.Errorf("unknown transform provider %q", t.Provider)
+	}
+}
+
+// transformSettings identifies the transformer output for the conversion
+// manifest: changing the provider, server, model or sampling settings
+// converts the chunks again instead of reusing them.
+func transformSettings(cfg *config.Config) string {
+	t := cfg.Transform
+	if t.Provider == "passthrough" {
+		return "passthrough"
+	}
+	return fmt.Sprintf("%s|%s|%s|%g|%d", t.Provider, t.BaseURL, t.Model, t.Temperature, t.MaxTokens)
+}
@@ -0,0 +1,174 @@
+// Package chunker splits text into pieces that fit a provider's input limits,
+// breaking at paragraph and sentence boundaries where it can and never inside
+// a UTF-8 sequence. Joining the pieces gives back the text exactly.
+package chunker
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits bounds the size of a chunk. Zero fields are unlimited.
+type Limits struct {
+	MaxChars  int // runes
+	MaxBytes  int // UTF-8 bytes
+	MaxTokens int // estimated with EstimateTokens
+}
+
+// terminators end a sentence in Japanese and English text.
+const terminators = "。！？.!?"
+
+// closers may follow a terminator and stay with its sentence (」。 ." ).
+const closers = "」』）)]】〉》\"'’”"
+
+// Split cuts text into chunks within l. Each chunk ends, in order of
+// preference, at a paragraph break, a sentence end, a line break or a space
+// in its second half; failing that at the latest of those anywhere in it, and
+// only as a last resort mid-sentence on a rune boundary. The whitespace at a
+// break stays with the chunk before it. A single rune larger than the limits
+// still makes a chunk on its own. Empty text gives no chunks.
+func Split(text string, l Limits) []string {
+	var chunks []string
+	for len(text) > 0 {
+		end := fit(text, l)
+		if end < len(text) {
+			end = breakPoint(text, end)
+		}
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+	return chunks
+}
+
+// fit returns the length of the longest prefix of text within l (at least one rune).
+func fit(text string, l Limits) int {
+	chars, quarterTokens := 0, 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:]) // 不正なバイトは1バイトずつ（range と同じ）
+		chars++
+		quarterTokens += runeQuarterTokens(r)
+		over := (l.MaxChars > 0 && chars > l.MaxChars) ||
+			(l.MaxBytes > 0 && i+size > l.MaxBytes) ||
+			(l.MaxTokens > 0 && (quarterTokens+3)/4 > l.MaxTokens)
+		if over {
+			if i == 0 {
+				return size
+			}
+			return i
+		}
+		i += size
+	}
+	return len(text)
+}
+
+// Break levels, most preferred first.
+const (
+	levelParagraph = iota
+	levelSentence
+	levelLine
+	levelSpace
+	levels
+)
+
+// breakPoint returns where to cut text given that text[:end] is the most that fits.
+func breakPoint(text string, end int) int {
+	var last [levels]int // 各レベルで最後に見つかった切れ目（0 = なし）
+	for i := 0; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:end])
+		next := i + size
+		i = next
+		switch {
+		case r == '\n':
+			// 改行の連続は最後の改行の直後で切る
+			j := next
+			for j < end && (text[j] == '\r' || text[j] == ' ' || text[j] == '\t') {
+				j++
+			}
+			if j < end && text[j] == '\n' {
+				continue
+			}
+			if isParagraphEnd(text, next-1) {
+				last[levelParagraph] = next
+			} else {
+				last[levelLine] = next
+			}
+		case strings.ContainsRune(terminators, r):
+			if j := sentenceEnd(text, next, r); j > 0 && j <= end {
+				last[levelSentence] = j
+			}
+		case unicode.IsSpace(r):
+			last[levelSpace] = next
+		}
+	}
+	for _, at := range last {
+		if at > 0 && at >= end/2 {
+			return at
+		}
+	}
+	best := 0
+	for _, at := range last {
+		if at > best {
+			best = at
+		}
+	}
+	if best > 0 {
+		return best
+	}
+	return end
+}
+
+// isParagraphEnd reports whether the line break at i follows an empty line.
+func isParagraphEnd(text string, i int) bool {
+	j := i - 1
+	for j >= 0 && (text[j] == '\r' || text[j] == ' ' || text[j] == '\t') {
+		j--
+	}
+	return j >= 0 && text[j] == '\n'
+}
+
+// sentenceEnd returns where the sentence ended by the terminator r (ending at
+// next) really ends: after closing brackets / quotes and trailing spaces. It
+// returns 0 when r does not end a sentence, like the periods of "3.14" or "Node.js".
+func sentenceEnd(text string, next int, r rune) int {
+	j := next
+	for j < len(text) {
+		c, size := utf8.DecodeRuneInString(text[j:])
+		if strings.ContainsRune(terminators, c) || strings.ContainsRune(closers, c) {
+			j += size
+			continue
+		}
+		break
+	}
+	if r == '.' || r == '!' || r == '?' {
+		// 半角の終止符は後ろが空白か文末のときだけ文の終わりとみなす
+		if j < len(text) {
+			c, _ := utf8.DecodeRuneInString(text[j:])
+			if !unicode.IsSpace(c) && c < utf8.RuneSelf {
+				return 0
+			}
+		}
+	}
+	for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
+		j++
+	}
+	return j
+}
+
+// EstimateTokens estimates the number of LLM tokens of s without a tokenizer:
+// a quarter token per ASCII byte and one token per other character, which
+// over- rather than underestimates for Japanese text.
+func EstimateTokens(s string) int {
+	q := 0
+	for _, r := range s {
+		q += runeQuarterTokens(r)
+	}
+	return (q + 3) / 4
+}
+
+func runeQuarterTokens(r rune) int {
+	if r < utf8.RuneSelf {
+		return 1
+	}
+	return 4
+}
@@ -0,0 +1,190 @@
+package chunker
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+// fragments are the pieces random test texts are made of: Japanese and English
+// words, every terminator and closer, line and paragraph breaks, and bytes that
+// are not valid UTF-8.
+var fragments = []string{
+	"今日は", "ニュース", "について", "話します", "東京", "生成AI", "ＧＰＴ", "😀",
+	"OpenAI", "released", "a", "new", "model", "3.14", "Node.js", "e.g.",
+	"。", "！", "？", ".", "!", "?", "」", "』", "）", ")", "\"", "’",
+	" ", "  ", "\t", "\n", "\n\n", "\r\n", "\n \n", "\xff", "\xe3\x81",
+}
+
+// randomText is a quick.Generator value of mixed Japanese / English text.
+type randomText string
+
+func (randomText) Generate(r *rand.Rand, size int) reflect.Value {
+	var b strings.Builder
+	for n := r.Intn(size*4 + 1); n > 0; n-- {
+		b.WriteString(fragments[r.Intn(len(fragments))])
+	}
+	return reflect.ValueOf(randomText(b.String()))
+}
+
+// randomLimits sets one to three limits, each large enough for any single rune.
+type randomLimits Limits
+
+func (randomLimits) Generate(r *rand.Rand, _ int) reflect.Value {
+	var l Limits
+	for l == (Limits{}) {
+		if r.Intn(2) == 0 {
+			l.MaxChars = 1 + r.Intn(40)
+		}
+		if r.Intn(2) == 0 {
+			l.MaxBytes = utf8.UTFMax + r.Intn(120)
+		}
+		if r.Intn(2) == 0 {
+			l.MaxTokens = 1 + r.Intn(30)
+		}
+	}
+	return reflect.ValueOf(randomLimits(l))
+}
+
+// checkSplit reports the first way Split(text, l) loses, reorders or
+// oversizes the text.
+func checkSplit(t *testing.T, text string, l Limits) {
+	t.Helper()
+	chunks := Split(text, l)
+	if got := strings.Join(chunks, ""); got != text {
+		t.Fatalf("Split(%q, %+v) = %q: joined %q", text, l, chunks, got)
+	}
+	// 切れ目はすべて文字の境界（不正なバイトは1バイトで1文字）
+	starts := map[int]bool{len(text): true}
+	for i := range text {
+		starts[i] = true
+	}
+	at := 0
+	for i, c := range chunks {
+		at += len(c)
+		if !starts[at] {
+			t.Fatalf("Split(%q, %+v): chunk %d %q ends inside a rune", text, l, i, c)
+		}
+		if c == "" {
+			t.Fatalf("Split(%q, %+v): chunk %d is empty", text, l, i)
+		}
+		if utf8.ValidString(text) && !utf8.ValidString(c) {
+			t.Fatalf("Split(%q, %+v): chunk %d %q is not valid UTF-8", text, l, i, c)
+		}
+		if l.MaxChars > 0 && utf8.RuneCountInString(c) > l.MaxChars {
+			t.Fatalf("Split(%q, %+v): chunk %d %q has more than %d chars", text, l, i, c, l.MaxChars)
+		}
+		if l.MaxBytes > 0 && len(c) > l.MaxBytes {
+			t.Fatalf("Split(%q, %+v): chunk %d %q has more than %d bytes", text, l, i, c, l.MaxBytes)
+		}
+		if l.MaxTokens > 0 && EstimateTokens(c) > l.MaxTokens {
+			t.Fatalf("Split(%q, %+v): chunk %d %q has more than %d tokens", text, l, i, c, l.MaxTokens)
+		}
+	}
+}
+
+func TestSplitProperties(t *testing.T) {
+	f := func(text randomText, l randomLimits) bool {
+		checkSplit(t, string(text), Limits(l))
+		return !t.Failed()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 20000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitInvalidUTF8(t *testing.T) {
+	// 不正なバイトは1バイトずつ数えられ、前後の正しい文字は壊さない
+	text := "あ\xffい\xe3\x81う"
+	want := []string{"あ\xff", "い\xe3", "\x81う"}
+	if got := Split(text, Limits{MaxBytes: 4}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) = %q, want %q", text, got, want)
+	}
+	checkSplit(t, text, Limits{MaxBytes: 4})
+}
+
+func TestSplitBreaks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		l    Limits
+		want []string
+	}{
+		{
+			name: "japanese without terminators falls back to runes",
+			text: "あいうえおかきくけこ",
+			l:    Limits{MaxBytes: 10},
+			want: []string{"あいう", "えおか", "きくけ", "こ"},
+		},
+		{
+			name: "english without spaces falls back to runes",
+			text: "abcdefghij",
+			l:    Limits{MaxChars: 4},
+			want: []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name: "mixed without terminators keeps emoji whole",
+			text: "ab😀cd😀e",
+			l:    Limits{MaxBytes: 5},
+			want: []string{"ab", "😀c", "d😀", "e"},
+		},
+		{
+			name: "japanese sentence end with closer",
+			text: "彼は「はい。」と言った。次の話です。",
+			l:    Limits{MaxChars: 12},
+			want: []string{"彼は「はい。」と言った。", "次の話です。"},
+		},
+		{
+			name: "english sentence end keeps the space",
+			text: "It costs 3.14 dollars. Node.js is next.",
+			l:    Limits{MaxChars: 30},
+			want: []string{"It costs 3.14 dollars. ", "Node.js is next."},
+		},
+		{
+			name: "paragraph before sentence",
+			text: "一文目。二文目。\n\n三文目。四文目。",
+			l:    Limits{MaxChars: 14},
+			want: []string{"一文目。二文目。\n\n", "三文目。四文目。"},
+		},
+		{
+			name: "fits as is",
+			text: "短い。",
+			l:    Limits{MaxChars: 10},
+			want: []string{"短い。"},
+		},
+		{
+			name: "empty",
+			text: "",
+			l:    Limits{MaxChars: 10},
+			want: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Split(tc.text, tc.l)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Split(%q, %+v) = %q, want %q", tc.text, tc.l, got, tc.want)
+			}
+			checkSplit(t, tc.text, tc.l)
+		})
+	}
+}
+
+func FuzzSplit(f *testing.F) {
+	f.Add("今日は東京の話です。OpenAI released a model. 次に\n\n生成AIの話。", 20, 40, 10)
+	f.Add("あいうえおかきくけこさしすせそ", 0, 8, 0)
+	f.Add("abcdefghijklmnopqrstuvwxyz", 5, 0, 0)
+	f.Add("😀😀😀\xff😀", 0, 5, 1)
+	f.Fuzz(func(t *testing.T, text string, maxChars, maxBytes, maxTokens int) {
+		l := Limits{MaxChars: maxChars % 100, MaxBytes: maxBytes % 400, MaxTokens: maxTokens % 100}
+		if l.MaxChars < 0 || l.MaxBytes < 0 || l.MaxTokens < 0 {
+			t.Skip()
+		}
+		if l.MaxBytes > 0 && l.MaxBytes < utf8.UTFMax {
+			l.MaxBytes = utf8.UTFMax
+		}
+		checkSplit(t, text, l)
+	})
+}
@@ -1,88 +1,447 @@
 package config
 
 import (
-    "encoding/json"
-    "os"
-    "path/filepath"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-    "github.com/joho/godotenv"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-// Config holds application-wide configuration populated from environment variables.
+// DefaultConfigFile is read when neither -config nor CONFIG_FILE is given. It is optional.
+const DefaultConfigFile = "config.yaml"
+
+// Config holds application-wide configuration.
+//
+// Values are resolved with the precedence command-line flags > environment
+// variables > config file (-config or CONFIG_FILE, YAML) > defaults. The flags
+// are applied by the commands on top of what Load returns. Each config file key is the
+// lower-cased name of its environment variable (OPENAI_API_KEY → openai_api_key).
 type Config struct {
-	OpenAIAPIKey    string
-	GmailTokenPath  string
-	CredentialsPath string
-	AudioDir        string
-	SecretsDir      string
-    DriveUploadEnabled bool
-    DriveFolderID      string
+	OpenAIAPIKey    string `yaml:"openai_api_key"`
+	GmailTokenPath  string `yaml:"gmail_token"`
+	CredentialsPath string `yaml:"google_credentials"`
+	AudioDir        string `yaml:"audio_dir"`
+	SecretsDir      string `yaml:"secrets_dir"`
+	// GoogleLoopbackHost / GoogleCallbackPath build the redirect URL of the interactive OAuth flow.
+	GoogleLoopbackHost string `yaml:"google_loopback_host"`
+	GoogleCallbackPath string `yaml:"google_callback_path"`
+	DriveUploadEnabled bool   `yaml:"drive_upload_enabled"`
+	DriveFolderID      string `yaml:"drive_folder_id"`
+	// MaxMessagesPerRun caps how many unprocessed messages a single run handles (0 = no limit).
+	MaxMessagesPerRun int `yaml:"max_messages_per_run"`
+	// MaxAttempts is how many times a message is tried before the runs give up
+	// on it. Given-up messages can still be run explicitly by ID.
+	MaxAttempts int `yaml:"max_attempts"`
+	// PollInterval / PollCron control the daemon schedule. PollCron (standard 5-field cron) wins when set.
+	PollInterval time.Duration `yaml:"poll_interval"`
+	PollCron     string        `yaml:"poll_cron"`
+	// StateDBPath is the embedded database recording per-message processing state and sync cursors.
+	StateDBPath string `yaml:"state_db_path"`
+	// LegacyProcessedIDsPath is the old processed-ID list, imported into the state store once.
+	LegacyProcessedIDsPath string `yaml:"processed_ids_path"`
+	// LegacyHistoryPath is the old Gmail sync cursor file, imported into the state store once.
+	LegacyHistoryPath string `yaml:"gmail_history_path"`
+	// Push notification (Pub/Sub) settings used by the -serve mode.
+	PushAddr              string `yaml:"push_addr"`
+	PushVerificationToken string `yaml:"push_verification_token"`
+	GmailPubSubTopic      string `yaml:"gmail_pubsub_topic"` // projects/<project>/topics/<topic>; empty disables Users.Watch
+	// IMAP mailbox settings used by the imap command (non-Gmail providers).
+	IMAPAddr     string `yaml:"imap_addr"` // host:port
+	IMAPUsername string `yaml:"imap_username"`
+	IMAPPassword string `yaml:"imap_password"` // app password
+	IMAPMailbox  string `yaml:"imap_mailbox"`
+	IMAPSecurity string `yaml:"imap_security"` // tls | starttls | none
+	// AttachmentMode decides what happens to PDF/TXT/DOCX attachments: append | chapters | skip.
+	AttachmentMode string `yaml:"attachment_mode"`
+	// ThreadMode narrates Gmail threads instead of single messages. A thread is
+	// narrated up to its newest reply; later replies give a new episode of the thread.
+	ThreadMode bool `yaml:"thread_mode"`
+	// GmailPostActions are applied to the source message once its episode is done,
+	// e.g. "label:podcast/done,read,archive". Requires the gmail.modify scope.
+	GmailPostActions string `yaml:"gmail_post_actions"`
+	// Cleanup strips signatures, quotes, newsletter headers/footers and rule matches
+	// from the raw text before podcast conversion.
+	CleanupEnabled   bool   `yaml:"cleanup_enabled"`
+	CleanupDetectors string `yaml:"cleanup_detectors"`  // comma separated: signature,quotes,footer,header
+	CleanupRulesFile string `yaml:"cleanup_rules_file"` // JSON file with per-source regex rules; missing file = no rules
+	// PodcastMode is "narration" (one narrator) or "dialogue" (host and guest
+	// with their own voices, see TTSConfig.Voices). Profiles can override it.
+	PodcastMode string `yaml:"podcast_mode"`
+	// ScriptFormat is the format of the podcast parts: "json" (versioned script
+	// with sections, speakers and pauses, via structured LLM output) or "text"
+	// (free-form text, for servers without structured outputs).
+	ScriptFormat string `yaml:"script_format"`
+	// PronunciationFile is the pronunciation dictionary (JSON) of the default
+	// profile, applied right before TTS. Missing file = no replacements.
+	PronunciationFile string `yaml:"pronunciation_file"`
+	// GmailQuery is the search query of the default profile.
+	GmailQuery string `yaml:"gmail_query"`
+	// ProfilesFile lists named newsletter profiles (YAML). Missing file = the default profile only.
+	// Profiles can also be given inline under "profiles:" in the config file.
+	ProfilesFile string    `yaml:"profiles_file"`
+	Profiles     []Profile `yaml:"profiles,omitempty"`
+	// TTS holds the voice settings. Fields left unset are taken from the legacy
+	// TTSConfigPath file (prompt/tts.config), then from the defaults.
+	TTS           TTSConfig `yaml:"tts"`
+	TTSConfigPath string    `yaml:"tts_config"`
+	// TTSConcurrency is how many podcast parts are synthesized at once.
+	// TTSRequestsPerMinute caps the speech requests across them (0 = no limit).
+	TTSConcurrency       int `yaml:"tts_concurrency"`
+	TTSRequestsPerMinute int `yaml:"tts_requests_per_minute"`
+	// Transform configures the LLM that turns the cleaned text into the podcast script.
+	Transform TransformConfig `yaml:"transform"`
+	// Fidelity checks each converted chunk against its source and retries the ones out of tolerance.
+	Fidelity FidelityConfig `yaml:"fidelity"`
+
+	// File is the config file that was read ("" when there was none).
+	File string `yaml:"-"`
 }
 
-// TTSConfig holds TTS-specific configuration from tts.config file.
+// TTSConfig holds TTS-specific configuration.
 type TTSConfig struct {
-	Model          string  `json:"model"`
-	Voice          string  `json:"voice"`
-	Speed          float64 `json:"speed"`
-	ResponseFormat string  `json:"response_format"`
-}
-
-// Load reads environment variables and returns Config with defaults applied.
-func Load() *Config {
-    // ルートの.envを読み込む（存在しなければ無視）
-    _ = godotenv.Load()
-	cfg := &Config{
-		OpenAIAPIKey:    os.Getenv("OPENAI_API_KEY"),
-		GmailTokenPath:  getEnv("GMAIL_TOKEN", ""),
-		CredentialsPath: getEnv("GOOGLE_CREDENTIALS", ""),
-		AudioDir:        getEnv("AUDIO_DIR", "audio"),
-		SecretsDir:      getEnv("SECRETS_DIR", "secrets"),
-        DriveUploadEnabled: getEnvBool("DRIVE_UPLOAD_ENABLED", false),
-        DriveFolderID:      getEnv("DRIVE_FOLDER_ID", ""),
+	Model          string  `json:"model" yaml:"model,omitempty"`
+	Voice          string  `json:"voice" yaml:"voice,omitempty"`
+	Speed          float64 `json:"speed" yaml:"speed,omitempty"`
+	ResponseFormat string  `json:"response_format" yaml:"response_format,omitempty"`
+	// Voices maps the speakers of the dialogue mode (HOST, GUEST) to voices.
+	// Speakers missing here use Voice.
+	Voices map[string]string `json:"voices,omitempty" yaml:"voices,omitempty"`
+}
+
+// VoiceFor returns the voice of a dialogue speaker.
+func (t TTSConfig) VoiceFor(speaker string) string {
+	for s, v := range t.Voices {
+		if strings.EqualFold(s, speaker) && v != "" {
+			return v
+		}
+	}
+	return t.Voice
+}
+
+// TransformConfig configures the text transformation (podcast conversion) stage.
+type TransformConfig struct {
+	// Provider is "openai" (OpenAI or any OpenAI-compatible server) or "passthrough" (no LLM).
+	Provider string `yaml:"provider"`
+	// BaseURL of the Chat Completions API; set it to use a local server such as
+	// Ollama (http://localhost:11434/v1) or llama.cpp.
+	BaseURL string `yaml:"base_url"`
+	// APIKey for BaseURL. Empty means OPENAI_API_KEY when BaseURL is the OpenAI API.
+	APIKey      string        `yaml:"api_key"`
+	Model       string        `yaml:"model"`
+	Temperature float64       `yaml:"temperature"`
+	MaxTokens   int           `yaml:"max_tokens"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// FidelityConfig configures the conversion fidelity check. The ratios are
+// shares between 0 and 1 except the length ratios (output / source chars).
+type FidelityConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retries is how many times a chunk out of tolerance is converted again (0 = only flag it).
+	Retries           int     `yaml:"retries"`
+	MinLengthRatio    float64 `yaml:"min_length_ratio"`
+	MaxLengthRatio    float64 `yaml:"max_length_ratio"`
+	MinCoverage       float64 `yaml:"min_coverage"`        // source sentences found in the output
+	MaxUnmatched      float64 `yaml:"max_unmatched"`       // output sentences not found in the source
+	MaxMissingNumbers float64 `yaml:"max_missing_numbers"` // numbers of the source missing from the output
+	MaxMissingNames   float64 `yaml:"max_missing_names"`   // proper nouns of the source missing from the output
+}
+
+// defaultTTS is used for the TTS fields set neither by env, config file nor tts.config.
+var defaultTTS = TTSConfig{
+	Model:          "tts-1-hd",
+	Voice:          "onyx",
+	Speed:          1.0,
+	ResponseFormat: "mp3",
+	Voices:         map[string]string{"HOST": "onyx", "GUEST": "nova"},
+}
+
+// defaults returns the built-in configuration.
+func defaults() *Config {
+	return &Config{
+		AudioDir:               "audio",
+		SecretsDir:             "secrets",
+		GoogleLoopbackHost:     "localhost",
+		GoogleCallbackPath:     "/auth/google/callback",
+		MaxMessagesPerRun:      5,
+		MaxAttempts:            3,
+		PollInterval:           time.Hour,
+		StateDBPath:            "state.db",
+		LegacyProcessedIDsPath: "procced_mail_ids.txt",
+		LegacyHistoryPath:      "gmail_history.json",
+		PushAddr:               ":8081",
+		IMAPMailbox:            "INBOX",
+		IMAPSecurity:           "tls",
+		AttachmentMode:         "append",
+		CleanupEnabled:         true,
+		CleanupDetectors:       "signature,footer,header",
+		CleanupRulesFile:       filepath.Join("prompt", "cleanup_rules.json"),
+		PodcastMode:            ModeNarration,
+		ScriptFormat:           "json",
+		PronunciationFile:      filepath.Join("prompt", "pronunciation.json"),
+		// 既定の検索条件: 件名に「週刊Life is beautiful」
+		GmailQuery:    "subject:\"週刊Life is beautiful\"",
+		ProfilesFile:  "profiles.yaml",
+		TTSConfigPath: filepath.Join("prompt", "tts.config"),
+		// OpenAI の TTS は利用枠の低いアカウントだと 50 RPM 程度なので、それに合わせる
+		TTSConcurrency:       3,
+		TTSRequestsPerMinute: 50,
+		Transform: TransformConfig{
+			Provider:  "openai",
+			BaseURL:   "https://api.openai.com/v1",
+			Model:     "gpt-4o",
+			MaxTokens: 8192,
+			Timeout:   180 * time.Second,
+		},
+		Fidelity: FidelityConfig{
+			Enabled:           true,
+			MinLengthRatio:    0.6,
+			MaxLengthRatio:    2.5,
+			MinCoverage:       0.8,
+			MaxUnmatched:      0.35,
+			MaxMissingNumbers: 0.1,
+			MaxMissingNames:   0.2,
+		},
+	}
+}
+
+// Load resolves the configuration from the defaults, the config file and the
+// environment (including .env). path is the config file; empty means
+// CONFIG_FILE, else DefaultConfigFile when it exists. Malformed values are
+// reported, not ignored.
+func Load(path string) (*Config, error) {
+	// ルートの.envを読み込む（存在しなければ無視）
+	_ = godotenv.Load()
+	cfg := defaults()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigFile
+	}
+	if err := cfg.loadFile(path, explicit); err != nil {
+		return nil, err
+	}
+
+	env := envLoader{}
+	env.str("OPENAI_API_KEY", &cfg.OpenAIAPIKey)
+	env.str("GMAIL_TOKEN", &cfg.GmailTokenPath)
+	env.str("GOOGLE_CREDENTIALS", &cfg.CredentialsPath)
+	env.str("AUDIO_DIR", &cfg.AudioDir)
+	env.str("SECRETS_DIR", &cfg.SecretsDir)
+	env.str("GOOGLE_LOOPBACK_HOST", &cfg.GoogleLoopbackHost)
+	env.str("GOOGLE_CALLBACK_PATH", &cfg.GoogleCallbackPath)
+	env.bool("DRIVE_UPLOAD_ENABLED", &cfg.DriveUploadEnabled)
+	env.str("DRIVE_FOLDER_ID", &cfg.DriveFolderID)
+	env.int("MAX_MESSAGES_PER_RUN", &cfg.MaxMessagesPerRun)
+	env.int("MAX_ATTEMPTS", &cfg.MaxAttempts)
+	env.duration("POLL_INTERVAL", &cfg.PollInterval)
+	env.str("POLL_CRON", &cfg.PollCron)
+	env.str("STATE_DB_PATH", &cfg.StateDBPath)
+	env.str("PROCESSED_IDS_PATH", &cfg.LegacyProcessedIDsPath)
+	env.str("GMAIL_HISTORY_PATH", &cfg.LegacyHistoryPath)
+	env.str("PUSH_ADDR", &cfg.PushAddr)
+	env.str("PUSH_VERIFICATION_TOKEN", &cfg.PushVerificationToken)
+	env.str("GMAIL_PUBSUB_TOPIC", &cfg.GmailPubSubTopic)
+	env.str("IMAP_ADDR", &cfg.IMAPAddr)
+	env.str("IMAP_USERNAME", &cfg.IMAPUsername)
+	env.str("IMAP_PASSWORD", &cfg.IMAPPassword)
+	env.str("IMAP_MAILBOX", &cfg.IMAPMailbox)
+	env.str("IMAP_SECURITY", &cfg.IMAPSecurity)
+	env.str("ATTACHMENT_MODE", &cfg.AttachmentMode)
+	env.bool("THREAD_MODE", &cfg.ThreadMode)
+	env.str("GMAIL_POST_ACTIONS", &cfg.GmailPostActions)
+	env.bool("CLEANUP_ENABLED", &cfg.CleanupEnabled)
+	env.str("CLEANUP_DETECTORS", &cfg.CleanupDetectors)
+	env.str("CLEANUP_RULES_FILE", &cfg.CleanupRulesFile)
+	env.str("PODCAST_MODE", &cfg.PodcastMode)
+	env.str("SCRIPT_FORMAT", &cfg.ScriptFormat)
+	env.str("PRONUNCIATION_FILE", &cfg.PronunciationFile)
+	env.str("GMAIL_QUERY", &cfg.GmailQuery)
+	env.str("PROFILES_FILE", &cfg.ProfilesFile)
+	env.str("TTS_CONFIG", &cfg.TTSConfigPath)
+	env.str("TTS_MODEL", &cfg.TTS.Model)
+	env.str("TTS_VOICE", &cfg.TTS.Voice)
+	env.float("TTS_SPEED", &cfg.TTS.Speed)
+	env.str("TTS_RESPONSE_FORMAT", &cfg.TTS.ResponseFormat)
+	env.int("TTS_CONCURRENCY", &cfg.TTSConcurrency)
+	env.int("TTS_REQUESTS_PER_MINUTE", &cfg.TTSRequestsPerMinute)
+	env.str("TRANSFORM_PROVIDER", &cfg.Transform.Provider)
+	env.str("TRANSFORM_BASE_URL", &cfg.Transform.BaseURL)
+	env.str("TRANSFORM_API_KEY", &cfg.Transform.APIKey)
+	env.str("TRANSFORM_MODEL", &cfg.Transform.Model)
+	env.float("TRANSFORM_TEMPERATURE", &cfg.Transform.Temperature)
+	env.int("TRANSFORM_MAX_TOKENS", &cfg.Transform.MaxTokens)
+	env.duration("TRANSFORM_TIMEOUT", &cfg.Transform.Timeout)
+	env.bool("FIDELITY_ENABLED", &cfg.Fidelity.Enabled)
+	env.int("FIDELITY_RETRIES", &cfg.Fidelity.Retries)
+	env.float("FIDELITY_MIN_COVERAGE", &cfg.Fidelity.MinCoverage)
+	env.float("FIDELITY_MAX_UNMATCHED", &cfg.Fidelity.MaxUnmatched)
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
 	}
+
 	if cfg.GmailTokenPath == "" {
 		cfg.GmailTokenPath = filepath.Join(cfg.SecretsDir, "token.json")
 	}
 	if cfg.CredentialsPath == "" {
 		cfg.CredentialsPath = filepath.Join(cfg.SecretsDir, "credentials.json")
 	}
-	return cfg
+	if cfg.OpenAIAPIKey == "" {
+		// 環境変数・設定ファイルになければ secrets/openai_api_key.txt を使う
+		if data, err := os.ReadFile(filepath.Join(cfg.SecretsDir, "openai_api_key.txt")); err == nil {
+			cfg.OpenAIAPIKey = strings.TrimSpace(string(data))
+		}
+	}
+	if err := cfg.fillTTS(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
-func getEnv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// loadFile overlays the YAML config file on cfg. A missing file is only an
+// error when it was asked for explicitly.
+func (c *Config) loadFile(path string, explicit bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
 	}
-	return def
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // 綴り間違いのキーを黙って無視しない
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	c.File = path
+	return nil
 }
 
-func getEnvBool(key string, def bool) bool {
-    v := getEnv(key, "")
-    if v == "" {
-        return def
-    }
-    switch v {
-    case "1", "true", "TRUE", "True", "yes", "YES", "on", "ON":
-        return true
-    case "0", "false", "FALSE", "False", "no", "NO", "off", "OFF":
-        return false
-    default:
-        return def
-    }
+// fillTTS fills the TTS fields that are still unset from the legacy tts.config
+// file and then from the defaults.
+func (c *Config) fillTTS() error {
+	var legacy TTSConfig
+	data, err := os.ReadFile(c.TTSConfigPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return fmt.Errorf("parse %s: %w", c.TTSConfigPath, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	for _, src := range []TTSConfig{legacy, defaultTTS} {
+		c.TTS = mergeTTS(src, c.TTS)
+	}
+	return nil
+}
+
+// mergeTTS returns base with the fields set in override applied.
+func mergeTTS(base, override TTSConfig) TTSConfig {
+	if override.Model != "" {
+		base.Model = override.Model
+	}
+	if override.Voice != "" {
+		base.Voice = override.Voice
+	}
+	if override.Speed != 0 {
+		base.Speed = override.Speed
+	}
+	if override.ResponseFormat != "" {
+		base.ResponseFormat = override.ResponseFormat
+	}
+	if len(override.Voices) > 0 {
+		voices := make(map[string]string, len(base.Voices)+len(override.Voices))
+		for s, v := range base.Voices {
+			voices[strings.ToUpper(s)] = v
+		}
+		for s, v := range override.Voices {
+			voices[strings.ToUpper(s)] = v
+		}
+		base.Voices = voices
+	}
+	return base
+}
+
+// envLoader overrides config values with environment variables, collecting
+// the malformed ones instead of silently falling back.
+type envLoader struct {
+	errs []error
+}
+
+func (e *envLoader) lookup(key string) (string, bool) {
+	v := strings.TrimSpace(os.Getenv(key))
+	return v, v != ""
+}
+
+func (e *envLoader) str(key string, dst *string) {
+	if v, ok := e.lookup(key); ok {
+		*dst = v
+	}
 }
 
-// LoadTTSConfig reads TTS configuration from prompt/tts.config file.
-func LoadTTSConfig() (*TTSConfig, error) {
-	configPath := filepath.Join("prompt", "tts.config")
-	data, err := os.ReadFile(configPath)
+func (e *envLoader) int(key string, dst *int) {
+	v, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		return nil, err
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not an integer", key, v))
+		return
 	}
+	*dst = n
+}
 
-	var cfg TTSConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+func (e *envLoader) float(key string, dst *float64) {
+	v, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not a number", key, v))
+		return
+	}
+	*dst = f
+}
+
+func (e *envLoader) duration(key string, dst *time.Duration) {
+	v, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not a duration (e.g. 30m, 1h)", key, v))
+		return
 	}
+	*dst = d
+}
 
-	return &cfg, nil
+func (e *envLoader) bool(key string, dst *bool) {
+	v, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		*dst = true
+	case "0", "false", "no", "off":
+		*dst = false
+	default:
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not a boolean (true/false)", key, v))
+	}
 }
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfileName is the name of the profile built from the environment
+// when no profiles file exists.
+const DefaultProfileName = "default"
+
+// DefaultOutputName is the episode file name template (without extension).
+const DefaultOutputName = "{{.Subject}}_{{.ID}}"
+
+// The podcast modes (PODCAST_MODE, profile mode).
+const (
+	ModeNarration = "narration"
+	ModeDialogue  = "dialogue"
+)
+
+// DefaultPromptPath is the conversion prompt used when a profile sets none.
+var DefaultPromptPath = filepath.Join("prompt", "convert_text_raw_to_podcast.txt")
+
+// DefaultDialoguePromptPath is the conversion prompt of the dialogue mode.
+var DefaultDialoguePromptPath = filepath.Join("prompt", "convert_text_raw_to_dialogue.txt")
+
+// promptFor returns the default prompt of a podcast mode.
+func promptFor(mode string) string {
+	if mode == ModeDialogue {
+		return DefaultDialoguePromptPath
+	}
+	return DefaultPromptPath
+}
+
+// Profile is one newsletter source: which messages to pick up and how to turn
+// them into episodes. Unset fields fall back to the global settings.
+type Profile struct {
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"` // Gmail search query
+	// CleanupRules is the cleanup rules file (see CLEANUP_RULES_FILE).
+	CleanupRules string `yaml:"cleanup_rules,omitempty"`
+	// Mode is the podcast mode (narration | dialogue); see PODCAST_MODE.
+	Mode string `yaml:"mode,omitempty"`
+	// Prompt is the conversion prompt file. Defaults to the prompt of Mode.
+	Prompt string `yaml:"prompt,omitempty"`
+	// Pronunciation is the pronunciation dictionary file (see PRONUNCIATION_FILE).
+	Pronunciation string `yaml:"pronunciation,omitempty"`
+	// AttachmentMode is append | chapters | skip; see ATTACHMENT_MODE.
+	AttachmentMode string `yaml:"attachment_mode,omitempty"`
+	// TTS overrides the fields of prompt/tts.config that are set.
+	TTS TTSConfig `yaml:"tts,omitempty"`
+	// OutputName is a text/template for the episode file name without extension.
+	// Fields: .Subject .ID .Date (YYYY-MM-DD) .Profile
+	OutputName    string `yaml:"output_name,omitempty"`
+	DriveFolderID string `yaml:"drive_folder_id,omitempty"`
+
+	outputTmpl *template.Template
+}
+
+type profilesFile struct {
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// DefaultProfile is the profile made of the global settings (GMAIL_QUERY,
+// CLEANUP_RULES_FILE, PRONUNCIATION_FILE, ATTACHMENT_MODE, DRIVE_FOLDER_ID, ...).
+func (c *Config) DefaultProfile() Profile {
+	return Profile{
+		Name:           DefaultProfileName,
+		Query:          c.GmailQuery,
+		CleanupRules:   c.CleanupRulesFile,
+		Mode:           c.PodcastMode,
+		Prompt:         promptFor(c.PodcastMode),
+		Pronunciation:  c.PronunciationFile,
+		AttachmentMode: c.AttachmentMode,
+		OutputName:     DefaultOutputName,
+		DriveFolderID:  c.DriveFolderID,
+	}
+}
+
+// LoadProfiles returns the profiles given inline in the config file, else the
+// ones in the profiles file (PROFILES_FILE). Without either the default
+// profile is the only one.
+func LoadProfiles(c *Config) ([]Profile, error) {
+	def := c.DefaultProfile()
+	var f profilesFile
+	data, err := os.ReadFile(c.ProfilesFile)
+	switch {
+	case len(c.Profiles) > 0:
+		f.Profiles = append([]Profile(nil), c.Profiles...)
+		err = nil
+	case errors.Is(err, os.ErrNotExist) || c.ProfilesFile == "":
+		f.Profiles = []Profile{{Name: DefaultProfileName}}
+	case err != nil:
+		return nil, err
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", c.ProfilesFile, err)
+		}
+		if len(f.Profiles) == 0 {
+			return nil, fmt.Errorf("%s: no profiles defined", c.ProfilesFile)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := range f.Profiles {
+		p := &f.Profiles[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("profile %d: name is required", i+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("profile %q is defined twice", p.Name)
+		}
+		seen[p.Name] = true
+		p.inherit(def)
+		if strings.TrimSpace(p.Query) == "" {
+			return nil, fmt.Errorf("profile %q: query is required", p.Name)
+		}
+		if err := oneOf("mode", p.Mode, ModeNarration, ModeDialogue); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		if err := oneOf("attachment_mode", p.AttachmentMode, "append", "chapters", "skip"); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		if err := validateTTS(p.TTS, true); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		if p.outputTmpl, err = template.New(p.Name).Option("missingkey=error").Parse(p.OutputName); err != nil {
+			return nil, fmt.Errorf("profile %q: output_name: %w", p.Name, err)
+		}
+	}
+	return f.Profiles, nil
+}
+
+func (p *Profile) inherit(def Profile) {
+	if p.Query == "" {
+		p.Query = def.Query
+	}
+	if p.CleanupRules == "" {
+		p.CleanupRules = def.CleanupRules
+	}
+	if p.Mode == "" {
+		p.Mode = def.Mode
+	}
+	p.Mode = strings.ToLower(p.Mode)
+	if p.Prompt == "" {
+		p.Prompt = promptFor(p.Mode)
+	}
+	if p.Pronunciation == "" {
+		p.Pronunciation = def.Pronunciation
+	}
+	if p.AttachmentMode == "" {
+		p.AttachmentMode = def.AttachmentMode
+	}
+	p.AttachmentMode = strings.ToLower(p.AttachmentMode)
+	if p.OutputName == "" {
+		p.OutputName = def.OutputName
+	}
+	if p.DriveFolderID == "" {
+		p.DriveFolderID = def.DriveFolderID
+	}
+}
+
+// FindProfile returns the profile called name; an empty name selects the first one.
+func FindProfile(profiles []Profile, name string) (*Profile, error) {
+	if len(profiles) == 0 {
+		return nil, errors.New("no profiles")
+	}
+	if name == "" {
+		return &profiles[0], nil
+	}
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown profile %q", name)
+}
+
+// SelectProfiles returns the profiles named in the comma separated list, or all of them.
+func SelectProfiles(profiles []Profile, names string) ([]Profile, error) {
+	if strings.TrimSpace(names) == "" {
+		return profiles, nil
+	}
+	var out []Profile
+	for _, n := range strings.Split(names, ",") {
+		p, err := FindProfile(profiles, strings.TrimSpace(n))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	return out, nil
+}
+
+// Dialogue reports whether the profile produces host / guest dialogue episodes.
+func (p *Profile) Dialogue() bool {
+	return p.Mode == ModeDialogue
+}
+
+// ApplyTTS returns base with the TTS fields set in the profile overridden.
+func (p *Profile) ApplyTTS(base TTSConfig) TTSConfig {
+	return mergeTTS(base, p.TTS)
+}
+
+// EpisodeName renders OutputName for a message.
+func (p *Profile) EpisodeName(subject, id string, date time.Time) (string, error) {
+	tmpl := p.outputTmpl
+	if tmpl == nil {
+		name := p.OutputName
+		if name == "" {
+			name = DefaultOutputName
+		}
+		var err error
+		if tmpl, err = template.New(p.Name).Option("missingkey=error").Parse(name); err != nil {
+			return "", fmt.Errorf("profile %q: output_name: %w", p.Name, err)
+		}
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	var b strings.Builder
+	err := tmpl.Execute(&b, map[string]string{
+		"Subject": subject,
+		"ID":      id,
+		"Date":    date.Local().Format("2006-01-02"),
+		"Profile": p.Name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("profile %q: output_name: %w", p.Name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ttsVoices are the voices of the OpenAI speech endpoint.
+var ttsVoices = []string{"alloy", "ash", "ballad", "coral", "echo", "fable", "nova", "onyx", "sage", "shimmer", "verse"}
+
+// ttsFormats are the response formats of the OpenAI speech endpoint.
+var ttsFormats = []string{"mp3", "opus", "aac", "flac", "wav", "pcm"}
+
+// Validate checks the values that do not depend on the command being run and
+// reports every problem at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if err := validateTTS(c.TTS, false); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxMessagesPerRun < 0 {
+		errs = append(errs, fmt.Errorf("max_messages_per_run: must be 0 (no limit) or more, got %d", c.MaxMessagesPerRun))
+	}
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("max_attempts: must be 1 or more, got %d", c.MaxAttempts))
+	}
+	if c.TTSConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("tts_concurrency: must be 1 or more, got %d", c.TTSConcurrency))
+	}
+	if c.TTSRequestsPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("tts_requests_per_minute: must be 0 (no limit) or more, got %d", c.TTSRequestsPerMinute))
+	}
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("poll_interval: must be positive, got %s", c.PollInterval))
+	}
+	if err := oneOf("attachment_mode", c.AttachmentMode, "append", "chapters", "skip"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := oneOf("podcast_mode", c.PodcastMode, ModeNarration, ModeDialogue); err != nil {
+		errs = append(errs, err)
+	}
+	if err := oneOf("script_format", c.ScriptFormat, "json", "text"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := oneOf("imap_security", c.IMAPSecurity, "tls", "starttls", "none"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateTransform(c.Transform); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateFidelity(c.Fidelity); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := LoadProfiles(c); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// validateTTS checks TTS settings. For partial (profile overrides) unset fields are fine.
+func validateTTS(t TTSConfig, partial bool) error {
+	var errs []error
+	if t.Model == "" && !partial {
+		errs = append(errs, errors.New("tts.model: required (e.g. tts-1, tts-1-hd, gpt-4o-mini-tts)"))
+	}
+	if t.Voice != "" || !partial {
+		if err := oneOf("tts.voice", t.Voice, ttsVoices...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (t.Speed != 0 || !partial) && (t.Speed < 0.25 || t.Speed > 4.0) {
+		errs = append(errs, fmt.Errorf("tts.speed: must be between 0.25 and 4.0, got %g", t.Speed))
+	}
+	if t.ResponseFormat != "" || !partial {
+		if err := oneOf("tts.response_format", t.ResponseFormat, ttsFormats...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for speaker, v := range t.Voices {
+		if err := oneOf("tts.voices."+speaker, v, ttsVoices...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateTransform(t TransformConfig) error {
+	if err := oneOf("transform.provider", t.Provider, "openai", "passthrough"); err != nil {
+		return err
+	}
+	if t.Provider == "passthrough" {
+		return nil
+	}
+	var errs []error
+	if t.Model == "" {
+		errs = append(errs, errors.New("transform.model: required (e.g. gpt-4o, or the model name of the local server)"))
+	}
+	if t.Temperature < 0 || t.Temperature > 2 {
+		errs = append(errs, fmt.Errorf("transform.temperature: must be between 0 and 2, got %g", t.Temperature))
+	}
+	if t.MaxTokens < 0 {
+		errs = append(errs, fmt.Errorf("transform.max_tokens: must be 0 (server default) or more, got %d", t.MaxTokens))
+	}
+	if !strings.HasPrefix(t.BaseURL, "http://") && !strings.HasPrefix(t.BaseURL, "https://") {
+		errs = append(errs, fmt.Errorf("transform.base_url: %q is not an http(s) URL", t.BaseURL))
+	}
+	return errors.Join(errs...)
+}
+
+func validateFidelity(f FidelityConfig) error {
+	var errs []error
+	if f.Retries < 0 {
+		errs = append(errs, fmt.Errorf("fidelity.retries: must be 0 or more, got %d", f.Retries))
+	}
+	if f.MinLengthRatio < 0 || f.MaxLengthRatio < f.MinLengthRatio {
+		errs = append(errs, fmt.Errorf("fidelity: want 0 <= min_length_ratio <= max_length_ratio, got %g and %g", f.MinLengthRatio, f.MaxLengthRatio))
+	}
+	for _, v := range []struct {
+		key string
+		val float64
+	}{
+		{"fidelity.min_coverage", f.MinCoverage},
+		{"fidelity.max_unmatched", f.MaxUnmatched},
+		{"fidelity.max_missing_numbers", f.MaxMissingNumbers},
+		{"fidelity.max_missing_names", f.MaxMissingNames},
+	} {
+		if v.val < 0 || v.val > 1 {
+			errs = append(errs, fmt.Errorf("%s: must be between 0 and 1, got %g", v.key, v.val))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsTTSVoice reports whether v is a voice of the OpenAI speech endpoint.
+func IsTTSVoice(v string) bool {
+	return oneOf("", v, ttsVoices...) == nil
+}
+
+func oneOf(key, v string, allowed ...string) error {
+	for _, a := range allowed {
+		if strings.EqualFold(v, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: unknown value %q (want one of %s)", key, v, strings.Join(allowed, ", "))
+}
+
+// RequireOpenAIKey fails with a hint when no OpenAI API key is configured.
+func (c *Config) RequireOpenAIKey() error {
+	if c.OpenAIAPIKey != "" {
+		return nil
+	}
+	return fmt.Errorf("openai api key is missing: set OPENAI_API_KEY, openai_api_key in the config file, or write it to %s",
+		filepath.Join(c.SecretsDir, "openai_api_key.txt"))
+}
+
+// RequireGoogleCredentials fails with a hint when the OAuth client file is missing.
+func (c *Config) RequireGoogleCredentials() error {
+	if _, err := os.Stat(c.CredentialsPath); err != nil {
+		return fmt.Errorf("google oauth client file %s not found: download the OAuth client (Desktop app) JSON from the Google Cloud console there, or set GOOGLE_CREDENTIALS", c.CredentialsPath)
+	}
+	return nil
+}
+
+// Redacted returns a copy of c with secrets masked, for display.
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, s := range []*string{&r.OpenAIAPIKey, &r.IMAPPassword, &r.PushVerificationToken, &r.Transform.APIKey} {
+		if *s != "" {
+			*s = "<redacted>"
+		}
+	}
+	return &r
+}
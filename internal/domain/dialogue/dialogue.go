@@ -0,0 +1,63 @@
+// Package dialogue is the speaker-tagged script of the two-host podcast mode.
+package dialogue
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The speakers of a dialogue script.
+const (
+	Host  = "HOST"
+	Guest = "GUEST"
+)
+
+// aliases are the Japanese tags the model sometimes writes instead of HOST / GUEST.
+var aliases = map[string]string{"ホスト": Host, "ゲスト": Guest}
+
+// Turn is what one speaker says before the other speaker takes over.
+type Turn struct {
+	Speaker string
+	Text    string
+}
+
+// tagRe matches a "SPEAKER:" tag at the start of a line (full-width colon too).
+var tagRe = regexp.MustCompile(`^\s*(?:[*［\[]*)([\p{L}]+)(?:[*］\]]*)\s*[:：]\s*`)
+
+// Parse splits a script into turns. A line starting with "SPEAKER:" starts a
+// turn, where SPEAKER is HOST, GUEST or one of extra (case-insensitive); other
+// lines continue the current turn. Text before the first tag is the host's.
+// Speakers are returned upper-cased.
+func Parse(script string, extra ...string) []Turn {
+	known := map[string]bool{Host: true, Guest: true}
+	for _, s := range extra {
+		known[strings.ToUpper(s)] = true
+	}
+
+	var turns []Turn
+	cur := Turn{Speaker: Host}
+	var lines []string
+	flush := func() {
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			cur.Text = text
+			turns = append(turns, cur)
+		}
+		lines = nil
+	}
+	for _, line := range strings.Split(script, "\n") {
+		if m := tagRe.FindStringSubmatchIndex(line); m != nil {
+			name := line[m[2]:m[3]]
+			if a, ok := aliases[name]; ok {
+				name = a
+			}
+			if name = strings.ToUpper(name); known[name] {
+				flush()
+				cur = Turn{Speaker: name}
+				line = line[m[1]:]
+			}
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return turns
+}
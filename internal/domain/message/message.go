@@ -1,5 +1,13 @@
 package message
 
+import "time"
+
+// ChapterBreak separates chapters in the raw text saved for a message (the body
+// and, in the "chapters" attachment mode, each attachment). Conversion never
+// puts text of two chapters into the same part, and cleanup handles each
+// chapter on its own.
+const ChapterBreak = "\f"
+
 // ID represents Gmail Message ID.
 type ID string
 
@@ -10,4 +18,31 @@ type EmailMessage struct {
 	ID      ID
 	Subject string
 	Body    string // plain text body extracted & aggregated
+	// MessageIDHeader is the RFC 822 Message-ID header. Unlike ID it is the same
+	// across sources (Gmail, mbox exports, ...) and is used to detect duplicates.
+	MessageIDHeader string
+
+	From     string    // decoded From header, e.g. "Name <addr@example.com>"
+	To       string    // decoded To header
+	Date     time.Time // Date header (or the time the source received the message); zero when unknown
+	ThreadID string    // provider thread ID, empty when the source has no threads
+	Labels   []string  // label / folder names
+	Links    []Link    // hyperlinks found in the HTML body, in document order
+	HTMLBody string    // raw HTML body, if any
+	// Attachments holds the attachments whose text could be extracted (PDF, TXT, DOCX).
+	Attachments []Attachment
+}
+
+// Attachment is a message attachment reduced to its text.
+type Attachment struct {
+	Filename string
+	MimeType string
+	Text     string
+}
+
+// Link is a hyperlink of the message. The text is what gets narrated; the URL
+// is kept for show notes.
+type Link struct {
+	Text string
+	URL  string
 }
@@ -0,0 +1,280 @@
+package message
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed Gmail-style search query (the GMAIL_QUERY syntax) that can
+// be evaluated against messages from sources without server-side search, such
+// as mbox archives.
+//
+// Supported: subject:, from:, to:, label:, after:, before:, newer_than:,
+// older_than:, bare words and "quoted phrases" (matched against subject, sender
+// and body), -negation and OR between adjacent terms. Other operators
+// (is:, has:, in:, ...) and (grouping) / {OR braces} are ignored and reported
+// by Ignored. An OR chain with an ignored operand is ignored as a whole, so it
+// never narrows down to its supported operands.
+type Query struct {
+	groups  [][]queryTerm // OR groups, all of which must match
+	ignored []string
+}
+
+// QueryTarget is the message data a Query is evaluated against.
+type QueryTarget struct {
+	Subject string
+	From    string
+	To      string
+	Body    string
+	Labels  []string
+	Date    time.Time
+}
+
+type queryTerm struct {
+	field  string // "" for free text
+	value  string
+	negate bool
+	time   time.Time
+}
+
+// ParseQuery parses q. now anchors relative dates (newer_than:/older_than:).
+func ParseQuery(q string, now time.Time) (*Query, error) {
+	tokens, err := tokenizeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	// まず OR で繋がったトークンをまとめる
+	var chains [][]string
+	orNext := false
+	for _, tok := range tokens {
+		switch {
+		case tok == "OR" && (len(chains) == 0 || orNext):
+			return nil, fmt.Errorf("query %q: OR without left operand", q)
+		case tok == "OR":
+			orNext = true
+		case orNext:
+			chains[len(chains)-1] = append(chains[len(chains)-1], tok)
+			orNext = false
+		default:
+			chains = append(chains, []string{tok})
+		}
+	}
+	if orNext {
+		return nil, fmt.Errorf("query %q: OR without right operand", q)
+	}
+
+	query := &Query{}
+	for _, chain := range chains {
+		group := make([]queryTerm, 0, len(chain))
+		for _, tok := range chain {
+			term, ok, err := parseQueryTerm(tok, now)
+			if err != nil {
+				return nil, fmt.Errorf("query %q: %w", q, err)
+			}
+			if !ok {
+				group = nil
+				break
+			}
+			group = append(group, term)
+		}
+		if group == nil {
+			query.ignored = append(query.ignored, strings.Join(chain, " OR "))
+			continue
+		}
+		query.groups = append(query.groups, group)
+	}
+	return query, nil
+}
+
+// Ignored returns the query terms that use unsupported operators.
+func (q *Query) Ignored() []string {
+	return q.ignored
+}
+
+// Match reports whether t satisfies the query.
+func (q *Query) Match(t QueryTarget) bool {
+	for _, group := range q.groups {
+		ok := false
+		for _, term := range group {
+			if term.match(t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (term queryTerm) match(t QueryTarget) bool {
+	var ok bool
+	switch term.field {
+	case "subject":
+		ok = containsFold(t.Subject, term.value)
+	case "from":
+		ok = containsFold(t.From, term.value)
+	case "to":
+		ok = containsFold(t.To, term.value)
+	case "label":
+		for _, l := range t.Labels {
+			if strings.EqualFold(strings.ReplaceAll(l, " ", "-"), strings.ReplaceAll(term.value, " ", "-")) {
+				ok = true
+				break
+			}
+		}
+	case "after":
+		ok = !t.Date.IsZero() && !t.Date.Before(term.time)
+	case "before":
+		ok = !t.Date.IsZero() && t.Date.Before(term.time)
+	default:
+		ok = containsFold(t.Subject, term.value) || containsFold(t.From, term.value) || containsFold(t.Body, term.value)
+	}
+	return ok != term.negate
+}
+
+func containsFold(s, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+func parseQueryTerm(tok string, now time.Time) (queryTerm, bool, error) {
+	term := queryTerm{}
+	if grouped(tok) {
+		// (グループ化) と {OR} は未対応。文字列として扱うと条件が変わってしまう
+		return term, false, nil
+	}
+	if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+		term.negate = true
+		tok = tok[1:]
+	}
+	field, value, hasField := strings.Cut(tok, ":")
+	if !hasField || strings.HasPrefix(tok, "\"") {
+		term.value = unquote(tok)
+		return term, true, nil
+	}
+	value = unquote(value)
+	switch strings.ToLower(field) {
+	case "subject", "from", "to", "label":
+		term.field = strings.ToLower(field)
+		term.value = value
+	case "after", "before":
+		t, err := parseQueryDate(value)
+		if err != nil {
+			return term, false, err
+		}
+		term.field = strings.ToLower(field)
+		term.time = t
+	case "newer_than", "older_than":
+		t, err := relativeQueryDate(value, now)
+		if err != nil {
+			return term, false, err
+		}
+		// newer_than:7d は after:(now-7d)、older_than:7d は before:(now-7d)
+		term.field = "after"
+		if strings.ToLower(field) == "older_than" {
+			term.field = "before"
+		}
+		term.time = t
+	default:
+		return term, false, nil
+	}
+	return term, true, nil
+}
+
+// grouped reports whether tok has parentheses or braces outside quotes.
+func grouped(tok string) bool {
+	inQuote := false
+	for _, r := range tok {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case strings.ContainsRune("(){}", r) && !inQuote:
+			return true
+		}
+	}
+	return false
+}
+
+func parseQueryDate(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	for _, layout := range []string{"2006/01/02", "2006-01-02", "2006/1/2"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", v)
+}
+
+func relativeQueryDate(v string, now time.Time) (time.Time, error) {
+	if len(v) < 2 {
+		return time.Time{}, fmt.Errorf("invalid relative date %q", v)
+	}
+	n, err := strconv.Atoi(v[:len(v)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid relative date %q", v)
+	}
+	switch v[len(v)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid relative date %q", v)
+}
+
+// tokenizeQuery splits q on whitespace, keeping "quoted phrases" (also after
+// an operator, e.g. subject:"a b") and (groups) / {braces} together.
+func tokenizeQuery(q string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	inQuote := false
+	depth := 0
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case (r == '(' || r == '{') && !inQuote:
+			depth++
+			cur.WriteRune(r)
+		case (r == ')' || r == '}') && !inQuote:
+			if depth == 0 {
+				return nil, fmt.Errorf("query %q: unbalanced %q", q, r)
+			}
+			depth--
+			cur.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuote && depth == 0:
+			if cur.Len() > 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("query %q: unterminated quote", q)
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("query %q: unbalanced parenthesis or brace", q)
+	}
+	if cur.Len() > 0 {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
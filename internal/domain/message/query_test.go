@@ -0,0 +1,101 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var queryNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+
+func TestParseQueryIgnored(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{`subject:a from:b`, nil},
+		{`is:unread subject:a`, []string{"is:unread"}},
+		{`subject:a is:unread OR subject:b`, []string{"is:unread OR subject:b"}},
+		{`is:unread OR subject:b`, []string{"is:unread OR subject:b"}},
+		{`subject:(a b)`, []string{"subject:(a b)"}},
+		{`{from:a from:b} subject:c`, []string{"{from:a from:b}"}},
+		{`-(a b)`, []string{"-(a b)"}},
+		{`subject:"a (b)"`, nil},
+		{`has:attachment in:inbox`, []string{"has:attachment", "in:inbox"}},
+	} {
+		query, err := ParseQuery(tc.q, queryNow)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.q, err)
+			continue
+		}
+		if got := query.Ignored(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseQuery(%q).Ignored() = %q, want %q", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, q := range []string{
+		`OR subject:a`,
+		`subject:a OR`,
+		`subject:a OR OR subject:b`,
+		`subject:"a`,
+		`subject:(a b`,
+		`a b)`,
+		`after:yesterday`,
+		`newer_than:7w`,
+	} {
+		if _, err := ParseQuery(q, queryNow); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded", q)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	weekly := QueryTarget{
+		Subject: "週刊Life is beautiful 2024/02/20",
+		From:    "Satoshi <news@example.com>",
+		To:      "me@example.org",
+		Body:    "今週のテーマは生成AIです。",
+		Labels:  []string{"INBOX", "Newsletters/Weekly"},
+		Date:    time.Date(2024, 2, 20, 9, 0, 0, 0, time.Local),
+	}
+	onlyB := QueryTarget{Subject: "b", From: "x@example.com", Date: weekly.Date}
+
+	for _, tc := range []struct {
+		q      string
+		target QueryTarget
+		want   bool
+	}{
+		{`subject:"週刊Life is beautiful"`, weekly, true},
+		{`subject:"life is BEAUTIFUL"`, weekly, true},
+		{`from:news@example.com`, weekly, true},
+		{`to:other@example.org`, weekly, false},
+		{`label:newsletters/weekly`, weekly, true},
+		{`生成AI`, weekly, true},
+		{`"テーマは生成AI"`, weekly, true},
+		{`-from:news@example.com`, weekly, false},
+		{`subject:週刊 -生成AI`, weekly, false},
+		{`after:2024/02/01 before:2024-03-01`, weekly, true},
+		{`after:2024/02/21`, weekly, false},
+		{`newer_than:14d`, weekly, true},
+		{`older_than:7d`, weekly, true},
+		{`newer_than:7d`, weekly, false},
+		{`subject:nothing OR from:example.com`, weekly, true},
+		{`subject:nothing OR from:nobody subject:週刊`, weekly, false},
+		// 未対応の演算子を含む OR は丸ごと無視し、右辺だけが前のグループに混ざらない
+		{`subject:a is:unread OR subject:b`, onlyB, false},
+		{`is:unread OR subject:b`, weekly, true},
+		{`subject:(a b)`, onlyB, true},
+		{``, onlyB, true},
+	} {
+		query, err := ParseQuery(tc.q, queryNow)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.q, err)
+			continue
+		}
+		if got := query.Match(tc.target); got != tc.want {
+			t.Errorf("ParseQuery(%q).Match(%q) = %t, want %t", tc.q, tc.target.Subject, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ThreadIDPrefix marks message IDs that stand for a thread, so a thread
+// episode gets its own output directories and state record.
+const ThreadIDPrefix = "thread-"
+
+// ThreadMessageID returns the message ID under which a thread is processed up
+// to and including its message upTo ("thread-<thread>-<upTo>"). Each new reply
+// gives a new ID, so a grown thread is narrated again as a new episode while
+// a done one is not repeated. An empty upTo stands for the whole thread as
+// found when it is fetched ("thread-<thread>").
+func ThreadMessageID(threadID string, upTo ID) ID {
+	if upTo == "" {
+		return ID(ThreadIDPrefix + threadID)
+	}
+	return ID(ThreadIDPrefix + threadID + "-" + string(upTo))
+}
+
+// IsThread reports whether id stands for a thread, returning the thread ID.
+func IsThread(id ID) (string, bool) {
+	s, ok := strings.CutPrefix(string(id), ThreadIDPrefix)
+	if !ok {
+		return "", false
+	}
+	threadID, _, _ := strings.Cut(s, "-")
+	return threadID, true
+}
+
+// ThreadUpTo returns the last message a thread ID covers, or "" when it
+// stands for the whole thread.
+func ThreadUpTo(id ID) ID {
+	s, ok := strings.CutPrefix(string(id), ThreadIDPrefix)
+	if !ok {
+		return ""
+	}
+	_, upTo, _ := strings.Cut(s, "-")
+	return ID(upTo)
+}
+
+// Post is one message of a thread with quotes and signature removed.
+type Post struct {
+	Speaker string
+	Date    time.Time
+	Body    string
+}
+
+// FormatThread renders the posts (oldest first) as one script in which every
+// post is introduced by its speaker, so the conversion keeps who said what.
+func FormatThread(subject string, posts []Post) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "スレッド「%s」（%d件のメッセージ）\n", subject, len(posts))
+	for i, p := range posts {
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "[%d] %sさんの発言", i+1, p.Speaker)
+		if !p.Date.IsZero() {
+			fmt.Fprintf(&b, "（%s）", p.Date.Local().Format("2006年1月2日 15:04"))
+		}
+		b.WriteString("\n")
+		b.WriteString(strings.TrimSpace(p.Body))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ThreadSubject drops reply / forward prefixes ("Re:", "Fwd:", "[list]"...)
+// repeated by mailing lists.
+func ThreadSubject(subject string) string {
+	s := strings.TrimSpace(subject)
+	for {
+		if strings.HasPrefix(s, "[") {
+			if end := strings.Index(s, "]"); end > 0 {
+				s = strings.TrimSpace(s[end+1:])
+				continue
+			}
+		}
+		lower := strings.ToLower(s)
+		trimmed := false
+		for _, p := range []string{"re:", "re：", "fwd:", "fw:", "aw:", "返信:", "転送:"} {
+			if strings.HasPrefix(lower, p) {
+				s = strings.TrimSpace(s[len(p):])
+				trimmed = true
+				break
+			}
+		}
+		if !trimmed {
+			return s
+		}
+	}
+}
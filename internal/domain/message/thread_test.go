@@ -0,0 +1,44 @@
+package message
+
+import "testing"
+
+func TestThreadMessageID(t *testing.T) {
+	for _, tc := range []struct {
+		threadID string
+		upTo     ID
+		want     ID
+	}{
+		{"18c2a", "", "thread-18c2a"},
+		{"18c2a", "18c2f", "thread-18c2a-18c2f"},
+	} {
+		id := ThreadMessageID(tc.threadID, tc.upTo)
+		if id != tc.want {
+			t.Errorf("ThreadMessageID(%q, %q) = %q, want %q", tc.threadID, tc.upTo, id, tc.want)
+		}
+		if threadID, ok := IsThread(id); !ok || threadID != tc.threadID {
+			t.Errorf("IsThread(%q) = %q, %t", id, threadID, ok)
+		}
+		if upTo := ThreadUpTo(id); upTo != tc.upTo {
+			t.Errorf("ThreadUpTo(%q) = %q, want %q", id, upTo, tc.upTo)
+		}
+	}
+	if _, ok := IsThread("18c2a"); ok {
+		t.Error(`IsThread("18c2a") = true`)
+	}
+	if upTo := ThreadUpTo("18c2a"); upTo != "" {
+		t.Errorf(`ThreadUpTo("18c2a") = %q`, upTo)
+	}
+}
+
+func TestThreadSubject(t *testing.T) {
+	for in, want := range map[string]string{
+		"Re: [dev] Re: 次回の勉強会":  "次回の勉強会",
+		"Fwd: RE: release plan": "release plan",
+		"返信: 転送: 議事録":           "議事録",
+		"Weekly digest":         "Weekly digest",
+	} {
+		if got := ThreadSubject(in); got != want {
+			t.Errorf("ThreadSubject(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
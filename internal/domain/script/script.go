@@ -0,0 +1,166 @@
+// Package script is the intermediate podcast script passed from the conversion
+// stage to the TTS stage: sections of segments, each segment one utterance.
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gmail-tts-app/internal/domain/dialogue"
+)
+
+// Version is the script format version written and accepted by this build.
+const Version = 1
+
+// MaxPauseMs caps the silence after a segment.
+const MaxPauseMs = 10000
+
+// Script is one podcast part.
+type Script struct {
+	Version  int       `json:"version"`
+	Title    string    `json:"title"`
+	Sections []Section `json:"sections"`
+}
+
+// Section groups segments under a title. Chapter marks the section as the
+// start of a chapter of the episode, named Title.
+type Section struct {
+	Title    string    `json:"title"`
+	Chapter  bool      `json:"chapter"`
+	Segments []Segment `json:"segments"`
+}
+
+// Segment is spoken in one voice: Voice if set, else the voice of Speaker
+// (HOST / GUEST in dialogue mode), else the narrator's.
+type Segment struct {
+	Text    string `json:"text"`
+	Speaker string `json:"speaker"`
+	Voice   string `json:"voice"`
+	// PauseAfterMs is the silence after the segment in milliseconds.
+	PauseAfterMs int `json:"pause_after_ms"`
+}
+
+// Schema is the JSON schema of Script for structured (schema-constrained) LLM
+// output. Every property is required and no others are allowed, as strict
+// structured outputs demand; optional values are empty strings / zero.
+var Schema = json.RawMessage(`{
+  "type": "object",
+  "additionalProperties": false,
+  "required": ["version", "title", "sections"],
+  "properties": {
+    "version": {"type": "integer", "enum": [1]},
+    "title": {"type": "string"},
+    "sections": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["title", "chapter", "segments"],
+        "properties": {
+          "title": {"type": "string"},
+          "chapter": {"type": "boolean"},
+          "segments": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "additionalProperties": false,
+              "required": ["text", "speaker", "voice", "pause_after_ms"],
+              "properties": {
+                "text": {"type": "string"},
+                "speaker": {"type": "string"},
+                "voice": {"type": "string"},
+                "pause_after_ms": {"type": "integer"}
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`)
+
+// Parse decodes and validates a script. Unknown fields are rejected so a
+// script written by a newer format is not half understood.
+func Parse(data []byte) (*Script, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s Script
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("decode script: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Load reads and validates the script file at path.
+func Load(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate checks the version and that every segment has something to say.
+func (s *Script) Validate() error {
+	if s.Version != Version {
+		return fmt.Errorf("script version %d is not supported (want %d)", s.Version, Version)
+	}
+	if len(s.Sections) == 0 {
+		return errors.New("script has no sections")
+	}
+	var errs []error
+	for i, sec := range s.Sections {
+		if len(sec.Segments) == 0 {
+			errs = append(errs, fmt.Errorf("section %d: no segments", i+1))
+		}
+		for j, seg := range sec.Segments {
+			if strings.TrimSpace(seg.Text) == "" {
+				errs = append(errs, fmt.Errorf("section %d segment %d: empty text", i+1, j+1))
+			}
+			if seg.PauseAfterMs < 0 || seg.PauseAfterMs > MaxPauseMs {
+				errs = append(errs, fmt.Errorf("section %d segment %d: pause_after_ms %d outside 0-%d", i+1, j+1, seg.PauseAfterMs, MaxPauseMs))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Text returns the spoken text, one segment per line.
+func (s *Script) Text() string {
+	var lines []string
+	for _, sec := range s.Sections {
+		for _, seg := range sec.Segments {
+			lines = append(lines, seg.Text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FromText is the degenerate script of a plain text part: one narrator segment.
+func FromText(text string) *Script {
+	return &Script{
+		Version:  Version,
+		Sections: []Section{{Segments: []Segment{{Text: strings.TrimSpace(text)}}}},
+	}
+}
+
+// FromDialogue is the script of a speaker-tagged dialogue text part: one
+// segment per turn (see dialogue.Parse for the tags).
+func FromDialogue(text string, speakers ...string) *Script {
+	var segs []Segment
+	for _, t := range dialogue.Parse(text, speakers...) {
+		segs = append(segs, Segment{Text: t.Text, Speaker: t.Speaker})
+	}
+	return &Script{Version: Version, Sections: []Section{{Segments: segs}}}
+}
@@ -0,0 +1,144 @@
+package state
+
+import "time"
+
+// Stage is a pipeline step a message goes through.
+type Stage string
+
+const (
+	StageFetched     Stage = "fetched"
+	StageRawSaved    Stage = "raw_saved"
+	StageCleaned     Stage = "cleaned" // signatures, footers etc. stripped from the raw text
+	StageConverted   Stage = "converted"
+	StageSynthesized Stage = "synthesized"
+	StageMerged      Stage = "merged"
+	StageUploaded    Stage = "uploaded"
+	// StagePostProcessed means the post-actions (label, mark read, archive) were applied to the source message.
+	StagePostProcessed Stage = "post_processed"
+)
+
+// Stages lists all stages in pipeline order.
+var Stages = []Stage{StageFetched, StageRawSaved, StageCleaned, StageConverted, StageSynthesized, StageMerged, StageUploaded, StagePostProcessed}
+
+// Status is the overall processing status of a message.
+type Status string
+
+const (
+	// StatusQueued means a sync listed the message but no attempt has started
+	// yet (it was over the per-run cap, or the run was interrupted).
+	StatusQueued     Status = "queued"
+	StatusInProgress Status = "in_progress"
+	StatusFailed     Status = "failed"
+	StatusDone       Status = "done"
+)
+
+// Record is the processing state of a single message.
+type Record struct {
+	MessageID string `json:"message_id"`
+	Subject   string `json:"subject,omitempty"`
+	From      string `json:"from,omitempty"` // sender, matched by per-source cleanup rules
+	// Date is when the message was sent, used in episode names.
+	Date time.Time `json:"date,omitempty"`
+	// Profile is the name of the profile the message was picked up by.
+	Profile string `json:"profile,omitempty"`
+	// MessageIDHeader is the RFC 822 Message-ID, used to spot the same message
+	// arriving from another source (e.g. an mbox import of mail already fetched from Gmail).
+	MessageIDHeader string    `json:"message_id_header,omitempty"`
+	Status          Status    `json:"status"`
+	Stage           Stage     `json:"stage,omitempty"` // last completed stage
+	Error           string    `json:"error,omitempty"`
+	ErrorAt         Stage     `json:"error_stage,omitempty"`
+	Attempts        int       `json:"attempts"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	// Artifacts holds the output of each completed stage (file/dir path, Drive link, ...).
+	Artifacts map[Stage]string `json:"artifacts,omitempty"`
+	// StageTimes holds when each stage last completed.
+	StageTimes map[Stage]time.Time `json:"stage_times,omitempty"`
+}
+
+// NewRecord returns an empty in-progress record for id.
+func NewRecord(id string) *Record {
+	now := time.Now()
+	return &Record{
+		MessageID:  id,
+		Status:     StatusInProgress,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Artifacts:  make(map[Stage]string),
+		StageTimes: make(map[Stage]time.Time),
+	}
+}
+
+// Retryable reports whether the message is unfinished and has had fewer than
+// maxAttempts attempts.
+func (r *Record) Retryable(maxAttempts int) bool {
+	return r.Status != StatusDone && r.Attempts < maxAttempts
+}
+
+// Begin marks the start of a new processing attempt.
+func (r *Record) Begin() {
+	r.Attempts++
+	r.Status = StatusInProgress
+	r.Error = ""
+	r.ErrorAt = ""
+	r.UpdatedAt = time.Now()
+}
+
+// Complete records stage as completed with its artifact. A previous failure of
+// the same stage is cleared.
+func (r *Record) Complete(stage Stage, artifact string) {
+	now := time.Now()
+	if r.ErrorAt == stage {
+		r.Error = ""
+		r.ErrorAt = ""
+		if r.Status == StatusFailed {
+			r.Status = StatusInProgress
+		}
+	}
+	if r.Artifacts == nil {
+		r.Artifacts = make(map[Stage]string)
+	}
+	if r.StageTimes == nil {
+		r.StageTimes = make(map[Stage]time.Time)
+	}
+	r.Stage = stage
+	r.Artifacts[stage] = artifact
+	r.StageTimes[stage] = now
+	r.UpdatedAt = now
+}
+
+// Fail records that stage failed with err.
+func (r *Record) Fail(stage Stage, err error) {
+	r.Status = StatusFailed
+	r.ErrorAt = stage
+	r.Error = err.Error()
+	r.UpdatedAt = time.Now()
+}
+
+// Done marks the whole pipeline as finished.
+func (r *Record) Done() {
+	r.Status = StatusDone
+	r.Error = ""
+	r.ErrorAt = ""
+	r.UpdatedAt = time.Now()
+}
+
+// Completed reports whether stage has been completed at least once.
+func (r *Record) Completed(stage Stage) bool {
+	_, ok := r.StageTimes[stage]
+	return ok
+}
+
+// Store persists processing records. Implementations must make each Put atomic.
+type Store interface {
+	// Get returns the record for id, or nil when the message is unknown.
+	Get(id string) (*Record, error)
+	Put(rec *Record) error
+	// FindByMessageIDHeader returns the record with the given RFC 822
+	// Message-ID, or nil when none is known.
+	FindByMessageIDHeader(header string) (*Record, error)
+	// List returns all records ordered by creation time.
+	List() ([]*Record, error)
+	Close() error
+}
@@ -0,0 +1,23 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Transformer rewrites text following instructions, e.g. turning a newsletter
+// into a podcast script. Concrete implementation wraps OpenAI, a local
+// OpenAI-compatible server, or nothing at all.
+type Transformer interface {
+	// Transform returns input rewritten according to prompt.
+	Transform(ctx context.Context, prompt, input string) (string, error)
+}
+
+// SchemaTransformer is implemented by transformers that can constrain their
+// output to a JSON schema (structured outputs).
+type SchemaTransformer interface {
+	Transformer
+	// TransformJSON returns input rewritten according to prompt as a JSON
+	// document valid against schema. name identifies the schema to the model.
+	TransformJSON(ctx context.Context, prompt, input, name string, schema json.RawMessage) (string, error)
+}
@@ -0,0 +1,110 @@
+package attachment
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Kind is the document format of an attachment we can narrate.
+type Kind string
+
+const (
+	KindText Kind = "txt"
+	KindPDF  Kind = "pdf"
+	KindDOCX Kind = "docx"
+)
+
+const docxMime = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+// Detect returns the kind of the attachment from its MIME type, falling back
+// to the file extension (mail clients often send application/octet-stream).
+func Detect(filename, mimeType string) (Kind, bool) {
+	mt, _, _ := mime.ParseMediaType(mimeType)
+	switch strings.ToLower(mt) {
+	case "text/plain":
+		return KindText, true
+	case "application/pdf":
+		return KindPDF, true
+	case docxMime:
+		return KindDOCX, true
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".text":
+		return KindText, true
+	case ".pdf":
+		return KindPDF, true
+	case ".docx":
+		return KindDOCX, true
+	}
+	return "", false
+}
+
+// Supported reports whether Extract can handle the attachment.
+func Supported(filename, mimeType string) bool {
+	_, ok := Detect(filename, mimeType)
+	return ok
+}
+
+// Extract returns the plain text of an attachment.
+func Extract(filename, mimeType string, data []byte) (string, error) {
+	kind, ok := Detect(filename, mimeType)
+	if !ok {
+		return "", fmt.Errorf("unsupported attachment %s (%s)", filename, mimeType)
+	}
+	var (
+		text string
+		err  error
+	)
+	switch kind {
+	case KindText:
+		_, params, _ := mime.ParseMediaType(mimeType)
+		text, err = decodeText(data, params["charset"])
+	case KindPDF:
+		text, err = pdfText(data)
+	case KindDOCX:
+		text, err = docxText(data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("extract %s: %w", filename, err)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n")), nil
+}
+
+// decodeText converts a text attachment to UTF-8. Without a declared charset
+// the Japanese encodings are tried in turn (Shift_JIS .txt files are common).
+func decodeText(data []byte, cs string) (string, error) {
+	if cs != "" {
+		return decodeAs(cs, data)
+	}
+	if utf8.Valid(data) {
+		return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), nil
+	}
+	for _, cand := range []string{"iso-2022-jp", "shift_jis", "euc-jp"} {
+		if cand == "iso-2022-jp" && !bytes.Contains(data, []byte("\x1b$")) {
+			continue
+		}
+		if s, err := decodeAs(cand, data); err == nil && !strings.ContainsRune(s, utf8.RuneError) {
+			return s, nil
+		}
+	}
+	return decodeAs("windows-1252", data)
+}
+
+func decodeAs(cs string, data []byte) (string, error) {
+	r, err := charset.NewReaderLabel(cs, bytes.NewReader(data))
+	if err != nil {
+		return string(data), nil
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
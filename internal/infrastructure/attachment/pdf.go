@@ -0,0 +1,47 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// pdfText extracts the text layer page by page. Scanned PDFs without a text
+// layer yield an error rather than an empty episode.
+func pdfText(data []byte) (text string, err error) {
+	// 壊れた PDF でパーサが panic することがあるため recover する
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("open pdf: %w", err)
+	}
+	var b strings.Builder
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		rows, err := p.GetTextByRow()
+		if err != nil {
+			return "", fmt.Errorf("read page %d: %w", i, err)
+		}
+		for _, row := range rows {
+			for _, w := range row.Content {
+				b.WriteString(w.S)
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", errors.New("pdf has no text layer")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,286 @@
+// Package fidelity checks that an LLM conversion kept the content of its input:
+// no dropped paragraphs, no invented passages, numbers and names preserved.
+package fidelity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tolerance is the range a conversion must stay within to pass.
+type Tolerance struct {
+	// MinLengthRatio / MaxLengthRatio bound output chars / source chars (whitespace ignored).
+	MinLengthRatio float64
+	MaxLengthRatio float64
+	// MinCoverage is the share of source chars whose sentence is found in the output.
+	MinCoverage float64
+	// MaxUnmatched is the share of output chars whose sentence is not found in the source.
+	MaxUnmatched float64
+	// MaxMissingNumbers / MaxMissingNames are the shares of numbers / proper nouns
+	// of the source that may be missing from the output.
+	MaxMissingNumbers float64
+	MaxMissingNames   float64
+}
+
+// sentenceMatch is the share of a sentence's character bigrams that must appear
+// in the other text's best matching sentence pair for the sentence to count as found.
+const sentenceMatch = 0.5
+
+// maxListed caps the dropped sentences / missing items listed in a Result.
+const maxListed = 10
+
+// Result is the outcome of Check.
+type Result struct {
+	SourceChars    int      `json:"source_chars"`
+	OutputChars    int      `json:"output_chars"`
+	LengthRatio    float64  `json:"length_ratio"`
+	Coverage       float64  `json:"coverage"`
+	Unmatched      float64  `json:"unmatched"`
+	Numbers        int      `json:"numbers"`
+	MissingNumbers []string `json:"missing_numbers,omitempty"`
+	Names          int      `json:"names"`
+	MissingNames   []string `json:"missing_names,omitempty"`
+	// Dropped are (the beginnings of) source sentences not found in the output.
+	Dropped []string `json:"dropped,omitempty"`
+	// Issues lists the checks that are out of tolerance; empty means OK.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// OK reports whether every check is within tolerance.
+func (r Result) OK() bool { return len(r.Issues) == 0 }
+
+// Better reports whether r is a better conversion than o (fewer issues, then higher coverage).
+func (r Result) Better(o Result) bool {
+	if len(r.Issues) != len(o.Issues) {
+		return len(r.Issues) < len(o.Issues)
+	}
+	return r.Coverage > o.Coverage
+}
+
+// Check compares the converted output with its source.
+func Check(source, output string, tol Tolerance) Result {
+	src, out := sentences(source), sentences(output)
+	r := Result{SourceChars: charCount(src), OutputChars: charCount(out)}
+	if r.SourceChars == 0 {
+		return r
+	}
+	r.LengthRatio = float64(r.OutputChars) / float64(r.SourceChars)
+
+	var covered int
+	for _, s := range src {
+		if found(s, out) {
+			covered += s.chars
+		} else if len(r.Dropped) < maxListed {
+			r.Dropped = append(r.Dropped, preview(s.text))
+		}
+	}
+	r.Coverage = float64(covered) / float64(r.SourceChars)
+	if r.OutputChars > 0 {
+		var unmatched int
+		for _, s := range out {
+			if !found(s, src) {
+				unmatched += s.chars
+			}
+		}
+		r.Unmatched = float64(unmatched) / float64(r.OutputChars)
+	}
+
+	normOut := normalize(output)
+	nums := numbers(normalize(source))
+	outNums := map[string]bool{}
+	for _, n := range numbers(normOut) {
+		outNums[n] = true
+	}
+	r.Numbers = len(nums)
+	var missingNums int
+	for _, n := range nums {
+		if !outNums[n] {
+			missingNums++
+			if len(r.MissingNumbers) < maxListed {
+				r.MissingNumbers = append(r.MissingNumbers, n)
+			}
+		}
+	}
+	names := properNouns(normalize(source))
+	lowerOut := strings.ToLower(normOut)
+	r.Names = len(names)
+	var missingNames int
+	for _, n := range names {
+		if !strings.Contains(lowerOut, strings.ToLower(n)) {
+			missingNames++
+			if len(r.MissingNames) < maxListed {
+				r.MissingNames = append(r.MissingNames, n)
+			}
+		}
+	}
+
+	if r.LengthRatio < tol.MinLengthRatio || r.LengthRatio > tol.MaxLengthRatio {
+		r.Issues = append(r.Issues, fmt.Sprintf("length ratio %.2f outside %.2f-%.2f", r.LengthRatio, tol.MinLengthRatio, tol.MaxLengthRatio))
+	}
+	if r.Coverage < tol.MinCoverage {
+		r.Issues = append(r.Issues, fmt.Sprintf("coverage %.2f below %.2f", r.Coverage, tol.MinCoverage))
+	}
+	if r.Unmatched > tol.MaxUnmatched {
+		r.Issues = append(r.Issues, fmt.Sprintf("unmatched output %.2f above %.2f", r.Unmatched, tol.MaxUnmatched))
+	}
+	if share(missingNums, len(nums)) > tol.MaxMissingNumbers {
+		r.Issues = append(r.Issues, fmt.Sprintf("%d of %d numbers missing", missingNums, len(nums)))
+	}
+	if share(missingNames, len(names)) > tol.MaxMissingNames {
+		r.Issues = append(r.Issues, fmt.Sprintf("%d of %d names missing", missingNames, len(names)))
+	}
+	return r
+}
+
+func share(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// sentence is a sentence with its character bigrams (whitespace and punctuation removed).
+type sentence struct {
+	text    string
+	chars   int
+	bigrams map[string]bool
+}
+
+// sentenceEnd splits at Japanese / Western sentence terminators and line breaks.
+// A period only ends a sentence when followed by a space, so 3.14 stays whole.
+var sentenceEnd = regexp.MustCompile(`[。！？!?]+|\.(\s|$)|\n+`)
+
+func sentences(text string) []sentence {
+	var out []sentence
+	last := 0
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		runes := contentRunes(s)
+		if len(runes) == 0 {
+			return
+		}
+		out = append(out, sentence{text: s, chars: len(runes), bigrams: bigrams(runes)})
+	}
+	for _, m := range sentenceEnd.FindAllStringIndex(text, -1) {
+		add(text[last:m[1]])
+		last = m[1]
+	}
+	add(text[last:])
+	return out
+}
+
+func charCount(ss []sentence) int {
+	n := 0
+	for _, s := range ss {
+		n += s.chars
+	}
+	return n
+}
+
+// contentRunes returns the letters and digits of s, normalized.
+func contentRunes(s string) []rune {
+	var rs []rune
+	for _, r := range normalize(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			rs = append(rs, unicode.ToLower(r))
+		}
+	}
+	return rs
+}
+
+func bigrams(rs []rune) map[string]bool {
+	m := make(map[string]bool, len(rs))
+	if len(rs) == 1 {
+		m[string(rs)] = true
+	}
+	for i := 0; i+1 < len(rs); i++ {
+		m[string(rs[i:i+2])] = true
+	}
+	return m
+}
+
+// found reports whether s appears in other: most of its bigrams occur in one
+// sentence of other or in two consecutive ones (the LLM may split or join sentences).
+func found(s sentence, other []sentence) bool {
+	for i := range other {
+		hit := 0
+		for b := range s.bigrams {
+			if other[i].bigrams[b] || (i+1 < len(other) && other[i+1].bigrams[b]) {
+				hit++
+			}
+		}
+		if float64(hit) >= sentenceMatch*float64(len(s.bigrams)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize maps full-width ASCII (Ａ, １, ．) to half-width.
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '！' && r <= '～' {
+			return r - 0xFEE0
+		}
+		return r
+	}, s)
+}
+
+var numberRe = regexp.MustCompile(`\d+(?:[.,]\d+)*`)
+
+// numbers returns the distinct numbers in s with thousands separators removed.
+func numbers(s string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range numberRe.FindAllString(s, -1) {
+		if strings.Contains(n, ",") {
+			n = strings.ReplaceAll(n, ",", "")
+		}
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// latinNameRe matches capitalized Latin words (OpenAI, Google, GPT-4).
+var latinNameRe = regexp.MustCompile(`\b[A-Z][A-Za-z0-9&'-]+`)
+
+// katakanaNameRe matches katakana words of three or more characters (ソフトバンク, テスラ).
+var katakanaNameRe = regexp.MustCompile(`[\p{Katakana}ー]{3,}`)
+
+// commonWords are capitalized only because they start an English sentence.
+var commonWords = map[string]bool{
+	"The": true, "This": true, "That": true, "These": true, "It": true, "We": true,
+	"You": true, "In": true, "On": true, "At": true, "An": true, "And": true,
+	"But": true, "If": true, "For": true, "To": true, "Of": true, "So": true,
+	"As": true, "My": true, "Our": true, "What": true, "How": true, "Why": true,
+}
+
+// properNouns returns the distinct proper noun candidates in s.
+func properNouns(s string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, re := range []*regexp.Regexp{latinNameRe, katakanaNameRe} {
+		for _, n := range re.FindAllString(s, -1) {
+			if commonWords[n] || seen[n] {
+				continue
+			}
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func preview(s string) string {
+	const max = 60
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max]) + "…"
+}
@@ -0,0 +1,142 @@
+package fidelity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tolerance is the default of the FIDELITY_* settings.
+var tolerance = Tolerance{
+	MinLengthRatio:    0.6,
+	MaxLengthRatio:    2.5,
+	MinCoverage:       0.8,
+	MaxUnmatched:      0.35,
+	MaxMissingNumbers: 0.1,
+	MaxMissingNames:   0.2,
+}
+
+const (
+	para1 = "OpenAIは新しいモデルを発表しました。価格は従来の半分で、応答速度は2倍になります。"
+	para2 = "一方でGoogleも検索に生成AIを組み込むと発表しています。利用者は3億人を超える見込みです。"
+	para3 = "国内ではソフトバンクが独自の大規模言語モデルを開発中で、2025年の提供開始を目指しています。"
+)
+
+var source = strings.Join([]string{para1, para2, para3}, "\n\n")
+
+func TestCheckFaithful(t *testing.T) {
+	// 話し言葉への言い換えと挨拶の追加は許容範囲
+	output := "こんにちは、今週のニュースです。\n\n" + source + "\n\nそれではまた来週。"
+	r := Check(source, output, tolerance)
+	if !r.OK() {
+		t.Fatalf("faithful conversion has issues: %+v", r)
+	}
+	if r.Coverage != 1 || len(r.Dropped) != 0 {
+		t.Errorf("Coverage = %.2f, Dropped = %q", r.Coverage, r.Dropped)
+	}
+	if r.Numbers != 3 || len(r.MissingNumbers) != 0 {
+		t.Errorf("Numbers = %d, MissingNumbers = %q", r.Numbers, r.MissingNumbers)
+	}
+}
+
+func TestCheckDroppedParagraph(t *testing.T) {
+	output := para1 + "\n\n" + para3
+	r := Check(source, output, tolerance)
+	if r.OK() {
+		t.Fatalf("dropped paragraph passed: %+v", r)
+	}
+	if r.Coverage >= tolerance.MinCoverage {
+		t.Errorf("Coverage = %.2f, want below %.2f", r.Coverage, tolerance.MinCoverage)
+	}
+	want := []string{"一方でGoogleも検索に生成AIを組み込むと発表しています。", "利用者は3億人を超える見込みです。"}
+	if !reflect.DeepEqual(r.Dropped, want) {
+		t.Errorf("Dropped = %q, want %q", r.Dropped, want)
+	}
+	if !reflect.DeepEqual(r.MissingNumbers, []string{"3"}) {
+		t.Errorf("MissingNumbers = %q, want [3]", r.MissingNumbers)
+	}
+	if !reflect.DeepEqual(r.MissingNames, []string{"Google"}) {
+		t.Errorf("MissingNames = %q, want [Google]", r.MissingNames)
+	}
+}
+
+func TestCheckInventedParagraph(t *testing.T) {
+	invented := "専門家の間では、この発表によって業界の勢力図が大きく塗り替えられるという見方が広がっており、株式市場でも関連銘柄が軒並み値上がりしました。" +
+		"さらに複数の大学が共同研究を始める計画を明らかにし、来年には成果の一部が公開される予定だと報じられています。"
+	output := source + "\n\n" + invented
+	r := Check(source, output, tolerance)
+	if r.OK() {
+		t.Fatalf("invented paragraph passed: %+v", r)
+	}
+	if r.Coverage != 1 {
+		t.Errorf("Coverage = %.2f, want 1", r.Coverage)
+	}
+	if r.Unmatched <= tolerance.MaxUnmatched {
+		t.Errorf("Unmatched = %.2f, want above %.2f", r.Unmatched, tolerance.MaxUnmatched)
+	}
+}
+
+func TestCheckSplitSentence(t *testing.T) {
+	// 1文を2文に分けても、隣り合う2文で見つかれば欠落ではない
+	src := "OpenAIは新しいモデルを発表し、価格は従来の半分で応答速度は2倍になると説明しました。"
+	output := "OpenAIは新しいモデルを発表しました。価格は従来の半分で、応答速度は2倍になると説明しています。"
+	r := Check(src, output, tolerance)
+	if !r.OK() || r.Coverage != 1 {
+		t.Fatalf("split sentence: %+v", r)
+	}
+	// 逆に2文を1文にまとめても同じ
+	r = Check(output, src, tolerance)
+	if !r.OK() || r.Coverage != 1 {
+		t.Fatalf("joined sentences: %+v", r)
+	}
+}
+
+func TestCheckFullWidthNumbers(t *testing.T) {
+	src := "売上高は１，２３４億円で、前年比１２．５％増えました。ＧＰＴ－４も引き続き好調です。"
+	output := "売上高は1234億円、前年と比べて12.5%の増加でした。GPT-4も引き続き好調です。"
+	r := Check(src, output, tolerance)
+	if r.Numbers != 3 || len(r.MissingNumbers) != 0 {
+		t.Errorf("Numbers = %d, MissingNumbers = %q", r.Numbers, r.MissingNumbers)
+	}
+	if r.Names != 1 || len(r.MissingNames) != 0 {
+		t.Errorf("Names = %d, MissingNames = %q", r.Names, r.MissingNames)
+	}
+
+	r = Check(src, "売上高は大きく増えました。GPT-4も引き続き好調です。", tolerance)
+	if !reflect.DeepEqual(r.MissingNumbers, []string{"1234", "12.5"}) {
+		t.Errorf("MissingNumbers = %q, want [1234 12.5]", r.MissingNumbers)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	got := numbers(normalize("3.14と1,000と１，０００と2024年と3.14"))
+	want := []string{"3.14", "1000", "2024"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("numbers = %q, want %q", got, want)
+	}
+}
+
+func TestProperNouns(t *testing.T) {
+	got := properNouns(normalize("The OpenAI team met Google. ソフトバンクとテスラ、そしてＡＩ。It was fine."))
+	want := []string{"OpenAI", "Google", "AI", "ソフトバンク", "テスラ"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("properNouns = %q, want %q", got, want)
+	}
+}
+
+func TestSentences(t *testing.T) {
+	var got []string
+	for _, s := range sentences("円周率は3.14です。Node.js is next. 本当？\n改行\n\n次") {
+		got = append(got, s.text)
+	}
+	want := []string{"円周率は3.14です。", "Node.js is next.", "本当？", "改行", "次"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sentences = %q, want %q", got, want)
+	}
+}
+
+func TestCheckEmptySource(t *testing.T) {
+	if r := Check("", "何か", tolerance); !r.OK() || r.SourceChars != 0 {
+		t.Errorf("empty source: %+v", r)
+	}
+}
@@ -0,0 +1,136 @@
+package gmail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"gmail-tts-app/internal/domain/message"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// PostActions are applied to the source message after its episode is done.
+type PostActions struct {
+	AddLabels []string // label names; missing labels are created
+	MarkRead  bool     // remove UNREAD
+	Archive   bool     // remove INBOX
+}
+
+// ParsePostActions parses a comma separated list such as
+// "label:podcast/done,read,archive".
+func ParsePostActions(v string) (PostActions, error) {
+	var a PostActions
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "read":
+			a.MarkRead = true
+		case item == "archive":
+			a.Archive = true
+		case strings.HasPrefix(item, "label:"):
+			name := strings.TrimSpace(strings.TrimPrefix(item, "label:"))
+			if name == "" {
+				return PostActions{}, fmt.Errorf("empty label name in %q", item)
+			}
+			a.AddLabels = append(a.AddLabels, name)
+		default:
+			return PostActions{}, fmt.Errorf("unknown post-action %q (want label:<name>, read or archive)", item)
+		}
+	}
+	return a, nil
+}
+
+// Empty reports whether there is nothing to do.
+func (a PostActions) Empty() bool {
+	return len(a.AddLabels) == 0 && !a.MarkRead && !a.Archive
+}
+
+func (a PostActions) String() string {
+	var parts []string
+	for _, l := range a.AddLabels {
+		parts = append(parts, "label:"+l)
+	}
+	if a.MarkRead {
+		parts = append(parts, "read")
+	}
+	if a.Archive {
+		parts = append(parts, "archive")
+	}
+	return strings.Join(parts, ",")
+}
+
+// ApplyPostActions labels / marks read / archives the message via
+// Messages.Modify (Threads.Modify for thread episodes). It needs the
+// gmail.modify scope.
+func (r *MessageRepository) ApplyPostActions(ctx context.Context, id message.ID, a PostActions) error {
+	if a.Empty() {
+		return nil
+	}
+	var add, remove []string
+	for _, name := range a.AddLabels {
+		labelID, err := r.ensureLabel(ctx, name)
+		if err != nil {
+			return err
+		}
+		add = append(add, labelID)
+	}
+	if a.MarkRead {
+		remove = append(remove, "UNREAD")
+	}
+	if a.Archive {
+		remove = append(remove, "INBOX")
+	}
+
+	if threadID, ok := message.IsThread(id); ok {
+		req := &gmail.ModifyThreadRequest{AddLabelIds: add, RemoveLabelIds: remove}
+		if _, err := r.srv.Users.Threads.Modify("me", threadID, req).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("gmail modify thread: %w", err)
+		}
+	} else {
+		req := &gmail.ModifyMessageRequest{AddLabelIds: add, RemoveLabelIds: remove}
+		if _, err := r.srv.Users.Messages.Modify("me", string(id), req).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("gmail modify message: %w", err)
+		}
+	}
+	log.Printf("[repo] post-actions applied to %s: %s", id, a)
+	return nil
+}
+
+// EnsureLabels creates the labels used by a that do not exist yet. Calling it
+// up front surfaces a missing gmail.modify grant before any paid stage runs.
+func (r *MessageRepository) EnsureLabels(ctx context.Context, a PostActions) error {
+	for _, name := range a.AddLabels {
+		if _, err := r.ensureLabel(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureLabel returns the ID of the user label called name, creating it when missing.
+func (r *MessageRepository) ensureLabel(ctx context.Context, name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.loadLabelsLocked(ctx); err != nil {
+		return "", fmt.Errorf("gmail list labels: %w", err)
+	}
+	for id, n := range r.labels {
+		if n == name {
+			return id, nil
+		}
+	}
+	l, err := r.srv.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("gmail create label %q: %w", name, err)
+	}
+	log.Printf("[repo] created label %q", name)
+	r.labels[l.Id] = l.Name
+	return l.Id, nil
+}
@@ -0,0 +1,223 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gmail-tts-app/internal/domain/message"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// HistoryStore persists the last synced Gmail historyId per sync key (the search query).
+type HistoryStore interface {
+	// LoadHistoryID returns the stored historyId for key, or 0 when none is known.
+	LoadHistoryID(key string) (uint64, error)
+	SaveHistoryID(key string, id uint64) error
+}
+
+// SyncResult is the outcome of an incremental sync.
+type SyncResult struct {
+	IDs []message.ID // newest first, like ListIDs
+	// HistoryID is the mailbox position the IDs are current to. Pass it to
+	// CommitHistory once they have been processed.
+	HistoryID uint64
+	// Full is true when the history was unavailable and a full list was done.
+	Full bool
+}
+
+// ListNewIDs returns messages matching query that were added to INBOX since the
+// last committed historyId. Without a stored historyId, or when Gmail reports it
+// as expired (404), it falls back to a full ListIDs.
+func (r *MessageRepository) ListNewIDs(ctx context.Context, query string) (*SyncResult, error) {
+	if r.history == nil {
+		return nil, errors.New("gmail: history store is not configured")
+	}
+	start, err := r.history.LoadHistoryID(query)
+	if err != nil {
+		return nil, fmt.Errorf("load history id: %w", err)
+	}
+	if start == 0 {
+		log.Printf("[repo] no history id for query. doing full list")
+		return r.fullSync(ctx, query)
+	}
+
+	added, latest, err := r.addedSince(ctx, start)
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			log.Printf("[repo] history id %d expired. doing full list", start)
+			return r.fullSync(ctx, query)
+		}
+		return nil, fmt.Errorf("gmail list history: %w", err)
+	}
+
+	var ids []message.ID
+	for _, id := range added {
+		ok, err := r.matchesQuery(ctx, id, query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	log.Printf("[repo] ListNewIDs: %d added since %d, %d matched query", len(added), start, len(ids))
+	return &SyncResult{IDs: ids, HistoryID: latest}, nil
+}
+
+// CommitHistory stores historyID as the sync position for query.
+func (r *MessageRepository) CommitHistory(query string, historyID uint64) error {
+	if r.history == nil || historyID == 0 {
+		return nil
+	}
+	return r.history.SaveHistoryID(query, historyID)
+}
+
+// Watch registers (or renews) Gmail push notifications for INBOX changes to the
+// given Pub/Sub topic. It returns the current historyId and when the watch expires.
+func (r *MessageRepository) Watch(ctx context.Context, topic string) (uint64, time.Time, error) {
+	req := &gmail.WatchRequest{
+		TopicName:         topic,
+		LabelIds:          []string{"INBOX"},
+		LabelFilterAction: "include",
+	}
+	res, err := r.srv.Users.Watch("me", req).Context(ctx).Do()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("gmail watch: %w", err)
+	}
+	return res.HistoryId, time.UnixMilli(res.Expiration), nil
+}
+
+// EmailAddress returns the address of the authenticated mailbox.
+func (r *MessageRepository) EmailAddress(ctx context.Context) (string, error) {
+	profile, err := r.srv.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("gmail get profile: %w", err)
+	}
+	return profile.EmailAddress, nil
+}
+
+func (r *MessageRepository) fullSync(ctx context.Context, query string) (*SyncResult, error) {
+	// 一覧取得より前の historyId を控えておき、取得中に届いたメールを取りこぼさないようにする
+	profile, err := r.srv.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("gmail get profile: %w", err)
+	}
+	ids, err := r.ListIDs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncResult{IDs: ids, HistoryID: profile.HistoryId, Full: true}, nil
+}
+
+// addedSince returns IDs of messages added to INBOX after start (newest first)
+// and the latest historyId of the mailbox.
+func (r *MessageRepository) addedSince(ctx context.Context, start uint64) ([]message.ID, uint64, error) {
+	call := r.srv.Users.History.List("me").
+		StartHistoryId(start).
+		HistoryTypes("messageAdded").
+		LabelId("INBOX").
+		MaxResults(500)
+
+	latest := start
+	seen := make(map[string]bool)
+	var ids []message.ID
+	err := call.Pages(ctx, func(res *gmail.ListHistoryResponse) error {
+		if res.HistoryId > latest {
+			latest = res.HistoryId
+		}
+		for _, h := range res.History {
+			for _, ma := range h.MessagesAdded {
+				if ma.Message == nil || seen[ma.Message.Id] {
+					continue
+				}
+				seen[ma.Message.Id] = true
+				ids = append(ids, message.ID(ma.Message.Id))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// History は古い順に返るため、ListIDs と同じ新しい順に揃える
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids, latest, nil
+}
+
+// matchesQuery reports whether message id matches the Gmail search query. The
+// History API has no query support, so the check is delegated to Gmail search
+// narrowed to the message's RFC 822 Message-ID.
+func (r *MessageRepository) matchesQuery(ctx context.Context, id message.ID, query string) (bool, error) {
+	if strings.TrimSpace(query) == "" {
+		return true, nil
+	}
+	rfcID, err := r.MessageIDHeader(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if rfcID == "" {
+		return false, nil
+	}
+	q := fmt.Sprintf("(%s) rfc822msgid:%s", query, strings.Trim(rfcID, "<>"))
+	res, err := r.srv.Users.Messages.List("me").Q(q).MaxResults(10).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("gmail search message: %w", err)
+	}
+	for _, m := range res.Messages {
+		if m.Id == string(id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MessageIDHeader returns the RFC 822 Message-ID of message id, fetching only
+// its metadata. Messages deleted from Gmail (and ones without the header) give "".
+func (r *MessageRepository) MessageIDHeader(ctx context.Context, id message.ID) (string, error) {
+	gm, err := r.srv.Users.Messages.Get("me", string(id)).Format("metadata").MetadataHeaders("Message-ID").Context(ctx).Do()
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			// 追加後に削除されたメッセージ
+			return "", nil
+		}
+		return "", fmt.Errorf("gmail get message metadata: %w", err)
+	}
+	return headerValue(gm.Payload, "Message-ID"), nil
+}
+
+// IsMessageID reports whether id looks like a Gmail message ID (hexadecimal),
+// as opposed to the prefixed IDs of threads, local files, mbox and IMAP messages.
+func IsMessageID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func headerValue(p *gmail.MessagePart, name string) string {
+	if p == nil {
+		return ""
+	}
+	for _, h := range p.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
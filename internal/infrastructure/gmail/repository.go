@@ -5,38 +5,205 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/mail"
 	"strings"
+	"sync"
+	"time"
 
 	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/attachment"
+	"gmail-tts-app/internal/infrastructure/htmltext"
 
 	"google.golang.org/api/gmail/v1"
 )
 
 // MessageRepository implements domain message.Repository backed by Gmail API.
 type MessageRepository struct {
-	srv *gmail.Service
+	srv     *gmail.Service
+	history HistoryStore
+
+	mu     sync.Mutex
+	labels map[string]string // label ID -> name, loaded on first use
+
+	skipAttachments bool
 }
 
 func NewMessageRepository(srv *gmail.Service) *MessageRepository {
 	return &MessageRepository{srv: srv}
 }
 
+// NewMessageRepositoryWithHistory creates a repository that remembers the last
+// synced historyId in store, enabling incremental sync via ListNewIDs.
+func NewMessageRepositoryWithHistory(srv *gmail.Service, store HistoryStore) *MessageRepository {
+	return &MessageRepository{srv: srv, history: store}
+}
+
+// WithoutAttachments stops GetByID from downloading attachments.
+func (r *MessageRepository) WithoutAttachments() *MessageRepository {
+	r.skipAttachments = true
+	return r
+}
+
 // GetByID fetches Gmail message, aggregates plain text / html to EmailMessage Body.
+// IDs made by message.ThreadMessageID return the thread (see GetThread).
 func (r *MessageRepository) GetByID(ctx context.Context, id message.ID) (*message.EmailMessage, error) {
+	if threadID, ok := message.IsThread(id); ok {
+		return r.GetThread(ctx, threadID, message.ThreadUpTo(id))
+	}
 	log.Printf("[repo] GetByID: %s", id)
 	gm, err := r.srv.Users.Messages.Get("me", string(id)).Format("full").Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("gmail get message: %w", err)
 	}
-	body := collectMessageText(gm)
-	subj := ""
-	for _, h := range gm.Payload.Headers {
-		if strings.ToLower(h.Name) == "subject" {
-			subj = h.Value
-			break
+	return r.toEmailMessage(ctx, gm), nil
+}
+
+// toEmailMessage converts a message fetched in "full" format.
+func (r *MessageRepository) toEmailMessage(ctx context.Context, gm *gmail.Message) *message.EmailMessage {
+	id := message.ID(gm.Id)
+	body, links := collectMessageText(gm)
+	labels, err := r.labelNames(ctx, gm.LabelIds)
+	if err != nil {
+		// ラベル名が引けなくても本文の処理は続ける
+		log.Printf("[repo] failed to resolve label names: %v", err)
+		labels = gm.LabelIds
+	}
+	var attachments []message.Attachment
+	if !r.skipAttachments {
+		attachments = r.attachments(ctx, id, gm.Payload)
+	}
+	return &message.EmailMessage{
+		ID:              id,
+		Subject:         headerValue(gm.Payload, "Subject"),
+		Body:            body,
+		MessageIDHeader: headerValue(gm.Payload, "Message-ID"),
+		From:            headerValue(gm.Payload, "From"),
+		To:              headerValue(gm.Payload, "To"),
+		Date:            messageDate(gm),
+		ThreadID:        gm.ThreadId,
+		Labels:          labels,
+		Links:           links,
+		HTMLBody:        extractHTML(gm.Payload),
+		Attachments:     attachments,
+	}
+}
+
+// attachments downloads the PDF/TXT/DOCX attachments of the message via
+// Attachments.Get and extracts their text. Unreadable attachments are logged
+// and skipped so they never block the episode.
+func (r *MessageRepository) attachments(ctx context.Context, id message.ID, p *gmail.MessagePart) []message.Attachment {
+	var out []message.Attachment
+	var visit func(p *gmail.MessagePart)
+	visit = func(p *gmail.MessagePart) {
+		if p == nil {
+			return
+		}
+		for _, c := range p.Parts {
+			visit(c)
+		}
+		if p.Filename == "" || p.Body == nil || !attachment.Supported(p.Filename, p.MimeType) {
+			return
+		}
+		encoded := p.Body.Data
+		if p.Body.AttachmentId != "" {
+			att, err := r.srv.Users.Messages.Attachments.Get("me", string(id), p.Body.AttachmentId).Context(ctx).Do()
+			if err != nil {
+				log.Printf("[repo] failed to download attachment %s: %v", p.Filename, err)
+				return
+			}
+			encoded = att.Data
+		}
+		data, err := base64.URLEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Printf("[repo] failed to decode attachment %s: %v", p.Filename, err)
+			return
+		}
+		text, err := attachment.Extract(p.Filename, p.MimeType, data)
+		if err != nil {
+			log.Printf("[repo] skipping attachment %s: %v", p.Filename, err)
+			return
+		}
+		if text != "" {
+			out = append(out, message.Attachment{Filename: p.Filename, MimeType: p.MimeType, Text: text})
+		}
+	}
+	visit(p)
+	if len(out) > 0 {
+		log.Printf("[repo] extracted %d attachment(s)", len(out))
+	}
+	return out
+}
+
+// labelNames maps label IDs (INBOX, Label_123, ...) to their display names.
+// The label list is fetched once per repository.
+func (r *MessageRepository) labelNames(ctx context.Context, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.loadLabelsLocked(ctx); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name, ok := r.labels[id]; ok {
+			names = append(names, name)
+			continue
+		}
+		names = append(names, id)
+	}
+	return names, nil
+}
+
+// loadLabelsLocked fetches the label list once. r.mu must be held.
+func (r *MessageRepository) loadLabelsLocked(ctx context.Context) error {
+	if r.labels != nil {
+		return nil
+	}
+	res, err := r.srv.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	r.labels = make(map[string]string, len(res.Labels))
+	for _, l := range res.Labels {
+		r.labels[l.Id] = l.Name
+	}
+	return nil
+}
+
+// messageDate returns the Date header, falling back to Gmail's internal date.
+func messageDate(gm *gmail.Message) time.Time {
+	if v := headerValue(gm.Payload, "Date"); v != "" {
+		if t, err := mail.ParseDate(v); err == nil {
+			return t
+		}
+	}
+	if gm.InternalDate > 0 {
+		return time.UnixMilli(gm.InternalDate)
+	}
+	return time.Time{}
+}
+
+// ListIDs pages through INBOX messages matching query and returns their IDs
+// in the order Gmail returns them (newest first).
+func (r *MessageRepository) ListIDs(ctx context.Context, query string) ([]message.ID, error) {
+	call := r.srv.Users.Messages.List("me").LabelIds("INBOX").MaxResults(100)
+	if strings.TrimSpace(query) != "" {
+		call = call.Q(query)
+	}
+	var ids []message.ID
+	err := call.Pages(ctx, func(res *gmail.ListMessagesResponse) error {
+		for _, m := range res.Messages {
+			ids = append(ids, message.ID(m.Id))
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gmail list messages: %w", err)
 	}
-	return &message.EmailMessage{ID: id, Subject: subj, Body: body}, nil
+	log.Printf("[repo] ListIDs: %d messages matched", len(ids))
+	return ids, nil
 }
 
 // ===== helpers (copied from existing handler) =====
@@ -45,7 +212,7 @@ func extractPlainText(p *gmail.MessagePart) string {
 	if p == nil {
 		return ""
 	}
-	if p.MimeType == "text/plain" && p.Body != nil && p.Body.Data != "" {
+	if p.MimeType == "text/plain" && p.Filename == "" && p.Body != nil && p.Body.Data != "" {
 		data, err := base64.URLEncoding.DecodeString(p.Body.Data)
 		if err == nil {
 			return string(data)
@@ -63,7 +230,7 @@ func extractHTML(p *gmail.MessagePart) string {
 	if p == nil {
 		return ""
 	}
-	if p.MimeType == "text/html" && p.Body != nil && p.Body.Data != "" {
+	if p.MimeType == "text/html" && p.Filename == "" && p.Body != nil && p.Body.Data != "" {
 		if data, err := base64.URLEncoding.DecodeString(p.Body.Data); err == nil {
 			return string(data)
 		}
@@ -76,29 +243,11 @@ func extractHTML(p *gmail.MessagePart) string {
 	return ""
 }
 
-func stripHTML(s string) string {
-	inTag := false
-	var b strings.Builder
-	for _, r := range s {
-		switch r {
-		case '<':
-			inTag = true
-		case '>':
-			inTag = false
-		default:
-			if !inTag {
-				b.WriteRune(r)
-			}
-		}
-	}
-	return strings.TrimSpace(b.String())
-}
-
 func gatherPlainText(p *gmail.MessagePart, out *[]string) {
 	if p == nil {
 		return
 	}
-	if p.MimeType == "text/plain" && p.Body != nil && p.Body.Data != "" {
+	if p.MimeType == "text/plain" && p.Filename == "" && p.Body != nil && p.Body.Data != "" {
 		if data, err := base64.URLEncoding.DecodeString(p.Body.Data); err == nil {
 			*out = append(*out, string(data))
 		}
@@ -108,28 +257,29 @@ func gatherPlainText(p *gmail.MessagePart, out *[]string) {
 	}
 }
 
-// collectMessageText replicates logic from original handler.
-func collectMessageText(msg *gmail.Message) string {
+// collectMessageText replicates logic from original handler. Links found in
+// the HTML part are returned separately so their URLs are not narrated.
+func collectMessageText(msg *gmail.Message) (string, []htmltext.Link) {
 	if msg == nil || msg.Payload == nil {
-		return ""
+		return "", nil
 	}
 	var plainParts []string
 	gatherPlainText(msg.Payload, &plainParts)
 	plainText := strings.TrimSpace(strings.Join(plainParts, "\n"))
 
-	if len([]rune(plainText)) >= 300 {
-		return plainText
-	}
-
+	var doc htmltext.Document
 	if html := extractHTML(msg.Payload); html != "" {
-		txt := stripHTML(html)
-		if len([]rune(txt)) > len([]rune(plainText)) {
-			return txt
-		}
+		doc = htmltext.Convert(html)
 	}
 
+	if len([]rune(plainText)) >= 300 {
+		return plainText, doc.Links
+	}
+	if len([]rune(doc.Text)) > len([]rune(plainText)) {
+		return doc.Text, doc.Links
+	}
 	if plainText != "" {
-		return plainText
+		return plainText, doc.Links
 	}
-	return msg.Snippet
+	return msg.Snippet, doc.Links
 }
@@ -0,0 +1,123 @@
+package gmail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/textclean"
+)
+
+// GetThread fetches a thread via Threads.Get and combines it into one
+// EmailMessage: messages are ordered by date, quoted replies and signatures
+// are stripped and every post is attributed to its sender. Messages after upTo
+// are left out (none when upTo is empty), so retrying an episode narrates the
+// same posts. The result has the ID message.ThreadMessageID(threadID, upTo).
+func (r *MessageRepository) GetThread(ctx context.Context, threadID string, upTo message.ID) (*message.EmailMessage, error) {
+	log.Printf("[repo] GetThread: %s", threadID)
+	th, err := r.srv.Users.Threads.Get("me", threadID).Format("full").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("gmail get thread: %w", err)
+	}
+	if len(th.Messages) == 0 {
+		return nil, fmt.Errorf("thread %s has no messages", threadID)
+	}
+	sort.SliceStable(th.Messages, func(i, j int) bool {
+		return th.Messages[i].InternalDate < th.Messages[j].InternalDate
+	})
+	if upTo != "" {
+		found := false
+		for i, gm := range th.Messages {
+			if gm.Id == string(upTo) {
+				th.Messages, found = th.Messages[:i+1], true
+				break
+			}
+		}
+		if !found {
+			// 削除されたメッセージまでのエピソードは、今あるスレッド全体で作る
+			log.Printf("[repo] thread %s: message %s not found. narrating the whole thread", threadID, upTo)
+		}
+	}
+
+	out := &message.EmailMessage{ID: message.ThreadMessageID(threadID, upTo), ThreadID: threadID}
+	var posts []message.Post
+	seenLabel := map[string]bool{}
+	for _, gm := range th.Messages {
+		m := r.toEmailMessage(ctx, gm)
+		body := textclean.Reply(m.Body)
+		if body == "" {
+			continue
+		}
+		posts = append(posts, message.Post{Speaker: speaker(m.From), Date: m.Date, Body: body})
+		if out.Subject == "" {
+			out.Subject = message.ThreadSubject(m.Subject)
+			out.From = m.From
+			out.To = m.To
+		}
+		out.Date = m.Date // 最後の発言の日時
+		out.Links = append(out.Links, m.Links...)
+		out.Attachments = append(out.Attachments, m.Attachments...)
+		for _, l := range m.Labels {
+			if !seenLabel[l] {
+				seenLabel[l] = true
+				out.Labels = append(out.Labels, l)
+			}
+		}
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("thread %s has no text to narrate", threadID)
+	}
+	out.Body = message.FormatThread(out.Subject, posts)
+	log.Printf("[repo] thread %s: %d message(s), %d with text", threadID, len(th.Messages), len(posts))
+	return out, nil
+}
+
+// ThreadIDsOf maps message IDs (newest first, as listed by Gmail) to thread
+// IDs covering their threads up to the first of them (see
+// message.ThreadMessageID), dropping duplicates and keeping the order. Thread
+// IDs in ids are kept as they are.
+func (r *MessageRepository) ThreadIDsOf(ctx context.Context, ids []message.ID) ([]message.ID, error) {
+	seen := map[string]bool{}
+	var out []message.ID
+	for _, id := range ids {
+		if threadID, ok := message.IsThread(id); ok {
+			if !seen[threadID] {
+				seen[threadID] = true
+				out = append(out, id)
+			}
+			continue
+		}
+		gm, err := r.srv.Users.Messages.Get("me", string(id)).Format("minimal").Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("gmail get message %s: %w", id, err)
+		}
+		if !seen[gm.ThreadId] {
+			seen[gm.ThreadId] = true
+			out = append(out, message.ThreadMessageID(gm.ThreadId, id))
+		}
+	}
+	return out, nil
+}
+
+// speaker returns the display name of a From header, or the local part of
+// the address when there is no name.
+func speaker(from string) string {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		if from = strings.TrimSpace(from); from != "" {
+			return from
+		}
+		return "不明な差出人"
+	}
+	if addr.Name != "" {
+		return addr.Name
+	}
+	if at := strings.Index(addr.Address, "@"); at > 0 {
+		return addr.Address[:at]
+	}
+	return addr.Address
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -19,30 +20,59 @@ import (
 )
 
 const (
-	credentialsFile = "credentials.json" // default file path, can be overridden by env GOOGLE_CREDENTIALS
-	tokenFile       = "token.json"       // default token path, can be overridden by env GMAIL_TOKEN
+	credentialsFile = "credentials.json" // default file name under secrets/, see Configure
+	tokenFile       = "token.json"       // default token name under secrets/, see Configure
 )
 
+// Settings are the files and the loopback redirect used by the OAuth helpers.
+type Settings struct {
+	CredentialsPath string // OAuth client JSON
+	TokenPath       string // saved token
+	LoopbackHost    string // host of the interactive flow's redirect URL
+	CallbackPath    string // path of the interactive flow's redirect URL
+}
+
+var settings = Settings{
+	CredentialsPath: filepath.Join("secrets", credentialsFile),
+	TokenPath:       filepath.Join("secrets", tokenFile),
+	LoopbackHost:    "localhost",
+	CallbackPath:    "/auth/google/callback",
+}
+
+// Configure sets the files and redirect used by the package (from config.Config).
+// Empty fields keep their defaults.
+func Configure(s Settings) {
+	if s.CredentialsPath != "" {
+		settings.CredentialsPath = s.CredentialsPath
+	}
+	if s.TokenPath != "" {
+		settings.TokenPath = s.TokenPath
+	}
+	if s.LoopbackHost != "" {
+		settings.LoopbackHost = s.LoopbackHost
+	}
+	if s.CallbackPath != "" {
+		settings.CallbackPath = s.CallbackPath
+	}
+}
+
+// GmailScope is the Gmail scope requested on consent. gmail.modify (instead of
+// readonly) lets post-actions label, mark read and archive processed messages.
+const GmailScope = gmail.GmailModifyScope
+
 // GoogleAuth wraps oauth2 configuration and helpers.
 type GoogleAuth struct {
 	config *oauth2.Config
 }
 
-// NewGoogleAuth creates GoogleAuth by reading credentials.json (or env-specified path).
+// NewGoogleAuth creates GoogleAuth by reading credentials.json (or the configured path).
 func NewGoogleAuth() (*GoogleAuth, error) {
-	credPath := os.Getenv("GOOGLE_CREDENTIALS")
-	if credPath == "" {
-		secretsDir := os.Getenv("SECRETS_DIR")
-		if secretsDir == "" {
-			secretsDir = "secrets"
-		}
-		credPath = filepath.Join(secretsDir, credentialsFile)
-	}
+	credPath := settings.CredentialsPath
     b, err := os.ReadFile(credPath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read client secret file: %w", err)
 	}
-	config, err := google.ConfigFromJSON(b, gmail.GmailReadonlyScope)
+	config, err := google.ConfigFromJSON(b, GmailScope)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
 	}
@@ -57,20 +87,13 @@ func NewGoogleAuth() (*GoogleAuth, error) {
 
 // NewGoogleAuthWithScopes creates GoogleAuth with specified OAuth scopes.
 func NewGoogleAuthWithScopes(scopes ...string) (*GoogleAuth, error) {
-    credPath := os.Getenv("GOOGLE_CREDENTIALS")
-    if credPath == "" {
-        secretsDir := os.Getenv("SECRETS_DIR")
-        if secretsDir == "" {
-            secretsDir = "secrets"
-        }
-        credPath = filepath.Join(secretsDir, credentialsFile)
-    }
+    credPath := settings.CredentialsPath
     b, err := os.ReadFile(credPath)
     if err != nil {
         return nil, fmt.Errorf("unable to read client secret file: %w", err)
     }
     if len(scopes) == 0 {
-        scopes = []string{gmail.GmailReadonlyScope}
+        scopes = []string{GmailScope}
     }
     config, err := google.ConfigFromJSON(b, scopes...)
     if err != nil {
@@ -108,17 +131,11 @@ func ObtainTokenInteractive(ctx context.Context) error {
     }
     defer ln.Close()
     // Use host for redirect URL to match OAuth client configuration (default: localhost)
-    host := os.Getenv("GOOGLE_LOOPBACK_HOST")
-    if host == "" {
-        host = "localhost"
-    }
+    host := settings.LoopbackHost
     addr := host + ":8080"
 
     // Keep path consistent with previous server callback (allow override)
-    callbackPath := "/auth/google/callback"
-    if p := os.Getenv("GOOGLE_CALLBACK_PATH"); p != "" {
-        callbackPath = p
-    }
+    callbackPath := settings.CallbackPath
     redirectURL := fmt.Sprintf("http://%s%s", addr, callbackPath)
     ga.SetRedirectURL(redirectURL)
 
@@ -185,16 +202,10 @@ func ObtainTokenInteractiveWithScopes(ctx context.Context, scopes ...string) err
     }
     defer ln.Close()
 
-    host := os.Getenv("GOOGLE_LOOPBACK_HOST")
-    if host == "" {
-        host = "localhost"
-    }
+    host := settings.LoopbackHost
     addr := host + ":8080"
 
-    callbackPath := "/auth/google/callback"
-    if p := os.Getenv("GOOGLE_CALLBACK_PATH"); p != "" {
-        callbackPath = p
-    }
+    callbackPath := settings.CallbackPath
     redirectURL := fmt.Sprintf("http://%s%s", addr, callbackPath)
     ga.SetRedirectURL(redirectURL)
 
@@ -252,34 +263,57 @@ func (ga *GoogleAuth) Exchange(ctx context.Context, code string) (*oauth2.Token,
 	return tok, nil
 }
 
-// SaveToken writes token to file path tokenFile.
+// storedToken is the token file format: the oauth2 token plus the scopes
+// granted on consent, so a token missing a newly required scope is detected.
+type storedToken struct {
+	*oauth2.Token
+	Scope string `json:"scope,omitempty"`
+}
+
+// SaveToken writes token to the configured token file.
 func SaveToken(token *oauth2.Token) error {
-	tokenPath := os.Getenv("GMAIL_TOKEN")
-	if tokenPath == "" {
-		secretsDir := os.Getenv("SECRETS_DIR")
-		if secretsDir == "" {
-			secretsDir = "secrets"
-		}
-		tokenPath = filepath.Join(secretsDir, tokenFile)
-	}
+	tokenPath := settings.TokenPath
 	f, err := os.Create(tokenPath)
 	if err != nil {
 		return fmt.Errorf("unable to cache oauth token: %w", err)
 	}
 	defer f.Close()
-	return json.NewEncoder(f).Encode(token)
+	scope, _ := token.Extra("scope").(string)
+	return json.NewEncoder(f).Encode(storedToken{Token: token, Scope: scope})
 }
 
-// TokenFromFile retrieves token from local file.
-func TokenFromFile() (*oauth2.Token, error) {
-	tokenPath := os.Getenv("GMAIL_TOKEN")
-	if tokenPath == "" {
-		secretsDir := os.Getenv("SECRETS_DIR")
-		if secretsDir == "" {
-			secretsDir = "secrets"
+// GrantedScopes returns the scopes recorded with the saved token. Tokens saved
+// before scopes were recorded return nil.
+func GrantedScopes() []string {
+	tokenPath := settings.TokenPath
+	b, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil
+	}
+	var st storedToken
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil
+	}
+	return strings.Fields(st.Scope)
+}
+
+// HasScopes reports whether the saved token was granted all of scopes.
+func HasScopes(scopes ...string) bool {
+	granted := map[string]bool{}
+	for _, s := range GrantedScopes() {
+		granted[s] = true
+	}
+	for _, s := range scopes {
+		if !granted[s] {
+			return false
 		}
-		tokenPath = filepath.Join(secretsDir, tokenFile)
 	}
+	return true
+}
+
+// TokenFromFile retrieves token from local file.
+func TokenFromFile() (*oauth2.Token, error) {
+	tokenPath := settings.TokenPath
 	f, err := os.Open(tokenPath)
 	if err != nil {
 		return nil, err
@@ -321,8 +355,8 @@ func BuildDriveService(ctx context.Context) (*drive.Service, error) {
     if err != nil {
         return nil, err
     }
-    // Request both Gmail readonly and Drive file scopes to allow unified token reuse
-    ga, err := NewGoogleAuthWithScopes(gmail.GmailReadonlyScope, drive.DriveFileScope)
+    // Request both Gmail and Drive file scopes to allow unified token reuse
+    ga, err := NewGoogleAuthWithScopes(GmailScope, drive.DriveFileScope)
     if err != nil {
         return nil, err
     }
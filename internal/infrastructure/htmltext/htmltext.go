@@ -0,0 +1,370 @@
+package htmltext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"gmail-tts-app/internal/domain/message"
+)
+
+// Link is a hyperlink found in the document. Only the text is narrated; the
+// URL is kept as a reference (e.g. for show notes).
+type Link = message.Link
+
+// Document is the narration-ready form of an HTML document.
+type Document struct {
+	Title string
+	Text  string
+	Links []Link
+}
+
+// ToText converts an HTML document to plain text for narration.
+func ToText(s string) string {
+	return Convert(s).Text
+}
+
+// Convert renders an HTML document as plain text: entities are decoded,
+// <head>/<style>/<script> and hidden elements are dropped, block elements
+// become paragraph breaks, lists are rendered as "- item" / "1. item" lines
+// and table rows as cells joined by " / ". Link text is kept in the text and
+// the URLs are returned separately in Links.
+func Convert(s string) Document {
+	root, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		// html.Parse only fails on reader errors; fall back to the raw text
+		return Document{Text: strings.TrimSpace(s)}
+	}
+	r := &renderer{}
+	var doc Document
+	if t := find(root, atom.Title); t != nil {
+		doc.Title = collapseSpace(textContent(t))
+	}
+	r.walk(root)
+	doc.Text = r.String()
+	doc.Links = r.links
+	return doc
+}
+
+var skipped = map[atom.Atom]bool{
+	atom.Head: true, atom.Style: true, atom.Script: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Iframe: true, atom.Object: true,
+	atom.Img: true, atom.Button: true, atom.Select: true, atom.Input: true,
+}
+
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Header: true, atom.Footer: true, atom.Main: true, atom.Aside: true,
+	atom.Nav: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Blockquote: true, atom.Pre: true,
+	atom.Address: true, atom.Figure: true, atom.Figcaption: true, atom.Form: true,
+	atom.Fieldset: true, atom.Center: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Hr: true, atom.Table: true, atom.Caption: true, atom.Body: true,
+}
+
+type list struct {
+	ordered bool
+	n       int
+}
+
+// renderer accumulates text. Breaks are recorded as pending and only written
+// before the next text, so empty blocks don't produce runs of blank lines.
+type renderer struct {
+	b        strings.Builder
+	pending  int // 1 = line break, 2 = paragraph break
+	space    bool
+	pre      int
+	lists    []*list
+	links    []Link
+	lastRune rune
+}
+
+func (r *renderer) String() string {
+	return strings.TrimSpace(r.b.String())
+}
+
+func (r *renderer) brk(n int) {
+	if n > r.pending {
+		r.pending = n
+	}
+	r.space = false
+}
+
+func (r *renderer) text(s string) {
+	if r.pre > 0 {
+		for i, line := range strings.Split(s, "\n") {
+			if i > 0 {
+				r.brk(1)
+			}
+			r.write(line)
+		}
+		return
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			r.space = true
+		}
+		return
+	}
+	if startsWithSpace(s) {
+		r.space = true
+	}
+	for i, f := range fields {
+		if i > 0 {
+			r.space = true
+		}
+		r.write(f)
+	}
+	if endsWithSpace(s) {
+		r.space = true
+	}
+}
+
+func (r *renderer) write(s string) {
+	if s == "" {
+		return
+	}
+	if r.b.Len() > 0 && r.pending > 0 {
+		r.b.WriteString(strings.Repeat("\n", r.pending))
+	} else if r.space && r.b.Len() > 0 {
+		// 和文同士の間の改行・空白や、閉じ記号の前の空白は詰める
+		first, _ := utf8.DecodeRuneInString(s)
+		if !(isCJK(r.lastRune) && isCJK(first)) && !strings.ContainsRune(".,;:!?)]}", first) {
+			r.b.WriteByte(' ')
+		}
+	}
+	r.pending = 0
+	r.space = false
+	r.b.WriteString(s)
+	r.lastRune, _ = utf8.DecodeLastRuneInString(s)
+}
+
+func (r *renderer) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		r.text(n.Data)
+		return
+	case html.CommentNode, html.DoctypeNode:
+		return
+	case html.ElementNode:
+		if skipped[n.DataAtom] || hidden(n) {
+			return
+		}
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		r.brk(1)
+		return
+	case atom.Ul, atom.Ol:
+		gap := 2
+		if len(r.lists) > 0 {
+			gap = 1 // nested list
+		}
+		r.brk(gap)
+		r.lists = append(r.lists, &list{ordered: n.DataAtom == atom.Ol})
+		r.children(n)
+		r.lists = r.lists[:len(r.lists)-1]
+		r.brk(gap)
+		return
+	case atom.Li:
+		r.brk(1)
+		r.write(r.bullet())
+		r.space = true
+		r.children(n)
+		r.brk(1)
+		return
+	case atom.Tr:
+		r.row(n)
+		return
+	case atom.A:
+		r.link(n)
+		return
+	case atom.Pre:
+		r.brk(2)
+		r.pre++
+		r.children(n)
+		r.pre--
+		r.brk(2)
+		return
+	}
+
+	block := n.Type == html.ElementNode && blocks[n.DataAtom]
+	if block {
+		r.brk(2)
+	}
+	r.children(n)
+	if block {
+		r.brk(2)
+	}
+}
+
+func (r *renderer) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.walk(c)
+	}
+}
+
+func (r *renderer) bullet() string {
+	if len(r.lists) == 0 {
+		return "-"
+	}
+	l := r.lists[len(r.lists)-1]
+	indent := strings.Repeat("  ", len(r.lists)-1)
+	if l.ordered {
+		l.n++
+		return indent + strconv.Itoa(l.n) + "."
+	}
+	return indent + "-"
+}
+
+// link keeps the anchor text and records the URL. Anchors whose text is the
+// URL itself (typical for tracking links) are not narrated.
+func (r *renderer) link(n *html.Node) {
+	href := strings.TrimSpace(attr(n, "href"))
+	text := collapseSpace(textContent(n))
+	if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") {
+		r.links = append(r.links, Link{Text: text, URL: href})
+		if looksLikeURL(text) {
+			return
+		}
+	}
+	r.children(n)
+}
+
+// row renders a table row. Layout tables (one cell, or cells holding block
+// content) are rendered as consecutive paragraphs; data rows become one line.
+func (r *renderer) row(n *html.Node) {
+	var cells []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || (c.DataAtom != atom.Td && c.DataAtom != atom.Th) || hidden(c) {
+			continue
+		}
+		sub := &renderer{lists: r.lists}
+		sub.children(c)
+		r.links = append(r.links, sub.links...)
+		if s := sub.String(); s != "" {
+			cells = append(cells, s)
+		}
+	}
+	if len(cells) == 0 {
+		return
+	}
+	inline := len(cells) > 1
+	for _, c := range cells {
+		if strings.Contains(c, "\n") {
+			inline = false
+			break
+		}
+	}
+	if inline {
+		r.brk(1)
+		r.write(strings.Join(cells, " / "))
+		r.brk(1)
+		return
+	}
+	for _, c := range cells {
+		for _, para := range strings.Split(c, "\n\n") {
+			r.brk(2)
+			for _, line := range strings.Split(para, "\n") {
+				r.brk(1)
+				r.write(line)
+			}
+		}
+		r.brk(2)
+	}
+}
+
+var displayNoneRe = regexp.MustCompile(`(?i)display\s*:\s*none|visibility\s*:\s*hidden`)
+
+// hidden reports elements newsletters hide from readers (preheaders etc.).
+func hidden(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, a := range n.Attr {
+		switch a.Key {
+		case "hidden":
+			return true
+		case "aria-hidden":
+			if a.Val == "true" {
+				return true
+			}
+		case "style":
+			if displayNoneRe.MatchString(a.Val) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if f := find(c, a); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var rec func(*html.Node)
+	rec = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			return
+		}
+		if n.Type == html.ElementNode && skipped[n.DataAtom] {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			rec(c)
+		}
+	}
+	rec(n)
+	return b.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func looksLikeURL(s string) bool {
+	s = strings.ToLower(s)
+	return s == "" || strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "www.")
+}
+
+func startsWithSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func endsWithSpace(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef)
+}
@@ -0,0 +1,255 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/mailparse"
+)
+
+// Security selects how the connection to the server is protected.
+type Security string
+
+const (
+	SecurityTLS      Security = "tls"      // implicit TLS (usually port 993)
+	SecurityStartTLS Security = "starttls" // plain connection upgraded with STARTTLS (usually port 143)
+	SecurityNone     Security = "none"     // no encryption; local test servers only
+)
+
+// ParseSecurity validates an IMAP_SECURITY value.
+func ParseSecurity(v string) (Security, error) {
+	switch s := Security(strings.ToLower(strings.TrimSpace(v))); s {
+	case "":
+		return SecurityTLS, nil
+	case SecurityTLS, SecurityStartTLS, SecurityNone:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown IMAP security %q (want tls, starttls or none)", v)
+}
+
+// Config holds the connection settings. Password is expected to be an app
+// password; providers with 2FA reject the account password over IMAP.
+type Config struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	Mailbox  string // defaults to INBOX
+	Security Security
+	// TLSConfig overrides the TLS settings (e.g. to trust a test server's certificate).
+	TLSConfig *tls.Config
+	// Timeout bounds each command; defaults to one minute.
+	Timeout time.Duration
+}
+
+// SearchCriteria narrows the messages returned by Search. Empty fields are ignored.
+type SearchCriteria struct {
+	Subject string
+	From    string
+	Since   time.Time
+}
+
+// Repository implements domain message.Repository over IMAP.
+//
+// Message IDs have the form imap-<uidvalidity>-<uid>, so the state store
+// deduplicates by UID and a mailbox rebuilt by the server (new UIDVALIDITY)
+// is detected instead of silently mapping to other messages.
+// Each call opens its own connection: processing a message takes minutes,
+// longer than servers keep idle sessions alive.
+type Repository struct {
+	cfg Config
+}
+
+func NewRepository(cfg Config) *Repository {
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+	if cfg.Security == "" {
+		cfg.Security = SecurityTLS
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+	return &Repository{cfg: cfg}
+}
+
+// Search returns the IDs of messages matching crit, newest first.
+func (r *Repository) Search(ctx context.Context, crit SearchCriteria) ([]message.ID, error) {
+	var ids []message.ID
+	err := r.session(ctx, func(c *client.Client, status *goimap.MailboxStatus) error {
+		sc := goimap.NewSearchCriteria()
+		if crit.Subject != "" {
+			sc.Header.Add("Subject", crit.Subject)
+		}
+		if crit.From != "" {
+			sc.Header.Add("From", crit.From)
+		}
+		sc.Since = crit.Since
+		uids, err := c.UidSearch(sc)
+		if err != nil {
+			return fmt.Errorf("search: %w", err)
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+		ids = make([]message.ID, 0, len(uids))
+		for _, uid := range uids {
+			ids = append(ids, FormatID(status.UidValidity, uid))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[imap] %d message(s) matched in %s", len(ids), r.cfg.Mailbox)
+	return ids, nil
+}
+
+// GetByID fetches the message without marking it as read and extracts its text.
+func (r *Repository) GetByID(ctx context.Context, id message.ID) (*message.EmailMessage, error) {
+	validity, uid, err := ParseID(id)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[imap] GetByID: %s", id)
+
+	var msg *message.EmailMessage
+	err = r.session(ctx, func(c *client.Client, status *goimap.MailboxStatus) error {
+		if status.UidValidity != validity {
+			return fmt.Errorf("mailbox %s was rebuilt (UIDVALIDITY %d, message has %d)", r.cfg.Mailbox, status.UidValidity, validity)
+		}
+		section := &goimap.BodySectionName{Peek: true}
+		set := new(goimap.SeqSet)
+		set.AddNum(uid)
+		ch := make(chan *goimap.Message, 1)
+		done := make(chan error, 1)
+		go func() { done <- c.UidFetch(set, []goimap.FetchItem{section.FetchItem()}, ch) }()
+
+		var body goimap.Literal
+		for m := range ch {
+			if b := m.GetBody(section); b != nil {
+				body = b
+			}
+		}
+		if err := <-done; err != nil {
+			return fmt.Errorf("fetch uid %d: %w", uid, err)
+		}
+		if body == nil {
+			return fmt.Errorf("message uid %d not found", uid)
+		}
+		parsed, err := mailparse.Parse(body)
+		if err != nil {
+			return fmt.Errorf("parse uid %d: %w", uid, err)
+		}
+		msg = parsed.EmailMessage(id)
+		msg.Attachments = parsed.ExtractAttachments()
+		msg.Labels = append(msg.Labels, r.cfg.Mailbox)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// FormatID builds the message ID for uid in a mailbox with the given UIDVALIDITY.
+func FormatID(uidValidity, uid uint32) message.ID {
+	return message.ID(fmt.Sprintf("imap-%d-%d", uidValidity, uid))
+}
+
+// ParseID is the inverse of FormatID.
+func ParseID(id message.ID) (uidValidity, uid uint32, err error) {
+	parts := strings.Split(string(id), "-")
+	if len(parts) != 3 || parts[0] != "imap" {
+		return 0, 0, fmt.Errorf("not an IMAP message id: %s", id)
+	}
+	v, err1 := strconv.ParseUint(parts[1], 10, 32)
+	u, err2 := strconv.ParseUint(parts[2], 10, 32)
+	if err1 != nil || err2 != nil || u == 0 {
+		return 0, 0, fmt.Errorf("not an IMAP message id: %s", id)
+	}
+	return uint32(v), uint32(u), nil
+}
+
+// session connects, logs in and selects the mailbox read-only, runs fn and logs out.
+func (r *Repository) session(ctx context.Context, fn func(*client.Client, *goimap.MailboxStatus) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c, err := r.dial()
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", r.cfg.Addr, err)
+	}
+	defer c.Logout()
+	c.Timeout = r.cfg.Timeout
+
+	// go-imap has no context support; closing the connection unblocks pending commands.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Terminate()
+		case <-stop:
+		}
+	}()
+
+	if err := c.Login(r.cfg.Username, r.cfg.Password); err != nil {
+		return fmt.Errorf("login as %s: %w", r.cfg.Username, err)
+	}
+	status, err := c.Select(r.cfg.Mailbox, true)
+	if err != nil {
+		return fmt.Errorf("select %s: %w", r.cfg.Mailbox, err)
+	}
+	if err := fn(c, status); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) dial() (*client.Client, error) {
+	if r.cfg.Addr == "" {
+		return nil, errors.New("IMAP address is not configured")
+	}
+	dialer := &net.Dialer{Timeout: r.cfg.Timeout}
+	switch r.cfg.Security {
+	case SecurityTLS:
+		return client.DialWithDialerTLS(dialer, r.cfg.Addr, r.tlsConfig())
+	case SecurityStartTLS:
+		c, err := client.DialWithDialer(dialer, r.cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.StartTLS(r.tlsConfig()); err != nil {
+			c.Logout()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+		return c, nil
+	case SecurityNone:
+		return client.DialWithDialer(dialer, r.cfg.Addr)
+	}
+	return nil, fmt.Errorf("unknown IMAP security %q", r.cfg.Security)
+}
+
+func (r *Repository) tlsConfig() *tls.Config {
+	if r.cfg.TLSConfig != nil {
+		return r.cfg.TLSConfig
+	}
+	host, _, err := net.SplitHostPort(r.cfg.Addr)
+	if err != nil {
+		host = r.cfg.Addr
+	}
+	return &tls.Config{ServerName: host}
+}
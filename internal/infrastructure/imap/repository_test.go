@@ -0,0 +1,260 @@
+package imap
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	goimap "github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/htmltext"
+)
+
+// The memory backend has one user, one INBOX with UIDVALIDITY 1, and a seed
+// message with UID 6 ("A little message, just for you" from contact@example.org).
+const (
+	testUser     = "username"
+	testPassword = "password"
+)
+
+var longPlain = strings.Repeat("This plain text part is long enough to be narrated as is. ", 8)
+
+const htmlOnlyRicher = `<html><body><h1>Weekly news</h1>` +
+	`<p>The HTML part of this newsletter carries the whole article, while the plain text part only says to open it in a browser.</p>` +
+	`<p>Read <a href="https://example.com/more">more</a>.</p></body></html>`
+
+// testMessages are appended to INBOX in order (UIDs 7, 8, 9).
+var testMessages = []struct {
+	date time.Time
+	raw  string
+}{
+	{
+		date: time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC),
+		raw: "From: Newsletter <news@example.com>\r\n" +
+			"To: me@example.org\r\n" +
+			"Subject: Weekly digest #1\r\n" +
+			"Date: Wed, 10 Jan 2024 09:00:00 +0000\r\n" +
+			"Message-ID: <digest-1@example.com>\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"\r\n" +
+			longPlain,
+	},
+	{
+		date: time.Date(2024, 2, 10, 9, 0, 0, 0, time.UTC),
+		raw: "From: Newsletter <news@example.com>\r\n" +
+			"To: me@example.org\r\n" +
+			"Subject: Weekly digest #2\r\n" +
+			"Date: Sat, 10 Feb 2024 09:00:00 +0000\r\n" +
+			"Message-ID: <digest-2@example.com>\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+			"\r\n" +
+			"--b1\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"\r\n" +
+			"View this email in your browser.\r\n" +
+			"--b1\r\n" +
+			"Content-Type: text/html; charset=utf-8\r\n" +
+			"Content-Transfer-Encoding: quoted-printable\r\n" +
+			"\r\n" +
+			htmlOnlyRicher + "\r\n" +
+			"--b1--\r\n",
+	},
+	{
+		date: time.Date(2024, 2, 20, 9, 0, 0, 0, time.UTC),
+		raw: "From: Someone Else <other@example.net>\r\n" +
+			"To: me@example.org\r\n" +
+			"Subject: Lunch?\r\n" +
+			"Date: Tue, 20 Feb 2024 09:00:00 +0000\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"\r\n" +
+			"Are you free on Friday?",
+	},
+}
+
+// startServer serves the memory backend with testMessages on a loopback port
+// and returns a repository connected to it without TLS.
+func startServer(t *testing.T) (*Repository, *memory.Backend) {
+	t.Helper()
+	be := memory.New()
+	user, err := be.Login(nil, testUser, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range testMessages {
+		if err := inbox.CreateMessage(nil, m.date, strings.NewReader(m.raw)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New(be)
+	s.AllowInsecureAuth = true
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	repo := NewRepository(Config{
+		Addr:     ln.Addr().String(),
+		Username: testUser,
+		Password: testPassword,
+		Security: SecurityNone,
+		Timeout:  10 * time.Second,
+	})
+	return repo, be
+}
+
+func TestSearch(t *testing.T) {
+	repo, _ := startServer(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		crit SearchCriteria
+		want []message.ID
+	}{
+		{"all, newest first", SearchCriteria{}, []message.ID{"imap-1-9", "imap-1-8", "imap-1-7", "imap-1-6"}},
+		{"subject", SearchCriteria{Subject: "Weekly digest"}, []message.ID{"imap-1-8", "imap-1-7"}},
+		{"from", SearchCriteria{From: "other@example.net"}, []message.ID{"imap-1-9"}},
+		{"since", SearchCriteria{Since: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, []message.ID{"imap-1-9", "imap-1-8", "imap-1-6"}},
+		{
+			"subject, from and since",
+			SearchCriteria{Subject: "digest", From: "news@example.com", Since: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+			[]message.ID{"imap-1-8"},
+		},
+		{"no match", SearchCriteria{Subject: "nothing like this"}, []message.ID{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := repo.Search(ctx, tc.crit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Search(%+v) = %v, want %v", tc.crit, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIDsAreStable(t *testing.T) {
+	repo, be := startServer(t)
+	ctx := context.Background()
+	crit := SearchCriteria{Subject: "Weekly digest"}
+
+	first, err := repo.Search(ctx, crit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 新着があっても既存のメッセージの ID は変わらない（重複排除のキー）
+	user, _ := be.Login(nil, testUser, testPassword)
+	inbox, _ := user.GetMailbox("INBOX")
+	newer := strings.Replace(testMessages[0].raw, "Weekly digest #1", "Weekly digest #3", 1)
+	if err := inbox.CreateMessage(nil, time.Now(), strings.NewReader(newer)); err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Search(ctx, crit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != len(first)+1 || !reflect.DeepEqual(second[1:], first) {
+		t.Fatalf("IDs changed after a new message: before %v, after %v", first, second)
+	}
+	if second[0] != "imap-1-10" {
+		t.Errorf("new message ID = %s, want imap-1-10", second[0])
+	}
+
+	for _, id := range first {
+		v, uid, err := ParseID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatID(v, uid); got != id {
+			t.Errorf("FormatID(ParseID(%s)) = %s", id, got)
+		}
+		msg, err := repo.GetByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != id {
+			t.Errorf("GetByID(%s).ID = %s", id, msg.ID)
+		}
+	}
+
+	// UIDVALIDITY が変わった（メールボックスが作り直された）ID は別のメッセージに化けない
+	if _, err := repo.GetByID(ctx, FormatID(2, 7)); err == nil || !strings.Contains(err.Error(), "rebuilt") {
+		t.Errorf("GetByID with a stale UIDVALIDITY: err = %v, want rebuilt mailbox", err)
+	}
+	if _, err := repo.GetByID(ctx, FormatID(1, 99)); err == nil {
+		t.Error("GetByID of a missing UID succeeded")
+	}
+	for _, bad := range []message.ID{"imap-1", "imap-x-7", "imap-1-0", "gmail-1-7"} {
+		if _, _, err := ParseID(bad); err == nil {
+			t.Errorf("ParseID(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestGetByID(t *testing.T) {
+	repo, be := startServer(t)
+	ctx := context.Background()
+
+	// 長い本文はテキストパートをそのまま読む
+	msg, err := repo.GetByID(ctx, "imap-1-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Body != strings.TrimSpace(longPlain) {
+		t.Errorf("plain body = %q, want %q", msg.Body, strings.TrimSpace(longPlain))
+	}
+	if msg.Subject != "Weekly digest #1" || msg.MessageIDHeader != "<digest-1@example.com>" {
+		t.Errorf("subject %q, Message-ID %q", msg.Subject, msg.MessageIDHeader)
+	}
+	if !strings.Contains(msg.From, "news@example.com") {
+		t.Errorf("From = %q", msg.From)
+	}
+	if want := time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC); !msg.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", msg.Date, want)
+	}
+	if !reflect.DeepEqual(msg.Labels, []string{"INBOX"}) {
+		t.Errorf("Labels = %v, want [INBOX]", msg.Labels)
+	}
+
+	// 短いテキストパート（「ブラウザで見る」だけ）は、Gmail と同じく内容の多い HTML を読む
+	msg, err = repo.GetByID(ctx, "imap-1-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := htmltext.Convert(htmlOnlyRicher)
+	if msg.Body != doc.Text {
+		t.Errorf("HTML fallback body = %q, want %q", msg.Body, doc.Text)
+	}
+	if !reflect.DeepEqual(msg.Links, doc.Links) {
+		t.Errorf("Links = %v, want %v", msg.Links, doc.Links)
+	}
+	if !strings.Contains(msg.HTMLBody, "Weekly news") {
+		t.Errorf("HTMLBody = %q", msg.HTMLBody)
+	}
+
+	// 既読にしない（Peek）
+	user, _ := be.Login(nil, testUser, testPassword)
+	inbox, _ := user.GetMailbox("INBOX")
+	for _, m := range inbox.(*memory.Mailbox).Messages {
+		for _, f := range m.Flags {
+			if m.Uid != 6 && f == goimap.SeenFlag {
+				t.Errorf("GetByID marked uid %d as read", m.Uid)
+			}
+		}
+	}
+}
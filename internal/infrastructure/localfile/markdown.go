@@ -0,0 +1,82 @@
+package localfile
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdHeading   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	mdImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdRefDef    = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s+\S+`)
+	mdAutoLink  = regexp.MustCompile(`<(https?://[^>]+)>`)
+	mdStrong    = regexp.MustCompile(`(\*\*|__|~~)(\S.*?)(\*\*|__|~~)`)
+	mdEmStar    = regexp.MustCompile(`\*(\S.*?)\*`)
+	mdEmUnder   = regexp.MustCompile(`(^|[^\w])_(\S.*?)_([^\w]|$)`)
+	mdCode      = regexp.MustCompile("`([^`]*)`")
+	mdListItem  = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+	mdQuote     = regexp.MustCompile(`^\s{0,3}>\s?`)
+	mdRule      = regexp.MustCompile(`^\s{0,3}(?:[-*_]\s*){3,}$`)
+	mdTableRule = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+)
+
+// markdownToText strips Markdown syntax so the text reads naturally aloud.
+// The first heading is returned as the title.
+func markdownToText(src string) (title, body string) {
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(normalizeNewlines(src), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		if mdRule.MatchString(line) || mdTableRule.MatchString(line) || mdRefDef.MatchString(line) {
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			heading := inlineMarkdown(m[1])
+			if title == "" {
+				title = heading
+			}
+			// 見出しは段落として区切る
+			out = append(out, "", heading, "")
+			continue
+		}
+		line = mdQuote.ReplaceAllString(line, "")
+		line = mdListItem.ReplaceAllString(line, "")
+		if strings.HasPrefix(trimmed, "|") {
+			cells := strings.Split(strings.Trim(trimmed, "|"), "|")
+			for i := range cells {
+				cells[i] = strings.TrimSpace(cells[i])
+			}
+			line = strings.Join(cells, "、")
+		}
+		out = append(out, inlineMarkdown(line))
+	}
+	body = strings.TrimSpace(collapseBlankLines(strings.Join(out, "\n")))
+	return title, body
+}
+
+func inlineMarkdown(s string) string {
+	s = mdImage.ReplaceAllString(s, "$1")
+	s = mdLink.ReplaceAllString(s, "$1")
+	s = mdAutoLink.ReplaceAllString(s, "$1")
+	s = mdCode.ReplaceAllString(s, "$1")
+	s = mdStrong.ReplaceAllString(s, "$2")
+	s = mdEmStar.ReplaceAllString(s, "$1")
+	s = mdEmUnder.ReplaceAllString(s, "$1$2$3")
+	return strings.TrimRight(s, " \t")
+}
+
+func collapseBlankLines(s string) string {
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	return s
+}
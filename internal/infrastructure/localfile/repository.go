@@ -0,0 +1,132 @@
+package localfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/htmltext"
+	"gmail-tts-app/internal/infrastructure/mailparse"
+)
+
+// Repository implements domain message.Repository for local .txt, .md, .eml
+// and .html files, so episodes can be made from content outside Gmail.
+// Files are registered with Add, which assigns them a stable message ID.
+type Repository struct {
+	mu    sync.Mutex
+	paths map[message.ID]string
+}
+
+func NewRepository() *Repository {
+	return &Repository{paths: make(map[message.ID]string)}
+}
+
+// IDForPath derives the message ID of a local file from its absolute path, so
+// the same file always maps to the same output directories and state record.
+func IDForPath(path string) (message.ID, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return message.ID("file-" + hex.EncodeToString(sum[:8])), nil
+}
+
+// Add registers path and returns its message ID.
+func (r *Repository) Add(path string) (message.ID, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	if !Supported(path) {
+		return "", fmt.Errorf("unsupported file type: %s", filepath.Ext(path))
+	}
+	id, err := IDForPath(path)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.paths[id] = path
+	r.mu.Unlock()
+	return id, nil
+}
+
+// Supported reports whether path has an extension this repository can read.
+func Supported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".txt", ".md", ".markdown", ".eml", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// GetByID reads the file registered as id and converts it to an EmailMessage.
+func (r *Repository) GetByID(ctx context.Context, id message.ID) (*message.EmailMessage, error) {
+	r.mu.Lock()
+	path, ok := r.paths[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("local file for %s is not registered", id)
+	}
+	log.Printf("[local] GetByID: %s (%s)", id, path)
+
+	msg, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = id
+	return msg, nil
+}
+
+// ReadFile builds an EmailMessage (without ID) from a local file based on its extension.
+func ReadFile(path string) (*message.EmailMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	msg, err := parseFile(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Subject == "" {
+		msg.Subject = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	// ヘッダーに日付がなければファイルの更新日時を使う
+	if msg.Date.IsZero() {
+		if fi, err := os.Stat(path); err == nil {
+			msg.Date = fi.ModTime()
+		}
+	}
+	return msg, nil
+}
+
+func parseFile(path string, data []byte) (*message.EmailMessage, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".eml":
+		m, err := mailparse.Parse(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		msg := m.EmailMessage("")
+		msg.Attachments = m.ExtractAttachments()
+		return msg, nil
+	case ".html", ".htm":
+		doc := htmltext.Convert(string(data))
+		return &message.EmailMessage{Subject: doc.Title, Body: doc.Text, Links: doc.Links, HTMLBody: string(data)}, nil
+	case ".md", ".markdown":
+		subject, body := markdownToText(string(data))
+		return &message.EmailMessage{Subject: subject, Body: body}, nil
+	default:
+		return &message.EmailMessage{Body: strings.TrimSpace(normalizeNewlines(string(data)))}, nil
+	}
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
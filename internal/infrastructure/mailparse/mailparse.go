@@ -0,0 +1,302 @@
+package mailparse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/attachment"
+	"gmail-tts-app/internal/infrastructure/htmltext"
+)
+
+// Message is an RFC 822 message reduced to what the pipeline narrates.
+type Message struct {
+	Header  mail.Header
+	Subject string
+	Text    string // plain text parts joined, or HTML converted to text when richer
+	HTML    string // first text/html part, if any
+	Links   []htmltext.Link
+	// Attachments are the raw attachments of a supported type (PDF, TXT, DOCX).
+	// Their text is only extracted by ExtractAttachments.
+	Attachments []RawAttachment
+}
+
+// RawAttachment is an attachment part with its transfer encoding undone.
+type RawAttachment struct {
+	Filename string
+	MimeType string
+	Data     []byte
+}
+
+// minPlainRunes mirrors the Gmail repository: shorter plain text bodies are
+// usually "view in browser" stubs, so the HTML part is preferred when richer.
+const minPlainRunes = 300
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// Parse reads an RFC 822 message (e.g. an .eml file) and extracts its subject
+// and narratable body from the MIME parts. Attachments of a supported type are
+// kept as raw bytes for ExtractAttachments; ones whose encoding is broken are
+// logged and skipped so they never block the body, as in the Gmail repository.
+func Parse(r io.Reader) (*Message, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+	msg := &Message{Header: m.Header, Subject: DecodeHeader(m.Header.Get("Subject"))}
+
+	var plain []string
+	err = walk(m.Header, m.Body, func(p part) error {
+		isText := p.mediaType == "text/plain" || p.mediaType == "text/html"
+		if p.attachment || (p.filename != "" && !isText) {
+			if !attachment.Supported(p.filename, p.mediaType) {
+				return nil
+			}
+			data, err := decodeTransfer(p.body, p.encoding)
+			if err != nil {
+				log.Printf("[mailparse] skipping attachment %s: %v", p.filename, err)
+				return nil
+			}
+			msg.Attachments = append(msg.Attachments, RawAttachment{Filename: p.filename, MimeType: p.contentType, Data: data})
+			return nil
+		}
+		if !isText {
+			return nil
+		}
+		body, err := decodeBody(p.body, p.encoding, p.params["charset"])
+		if err != nil {
+			return err
+		}
+		if p.mediaType == "text/plain" {
+			plain = append(plain, body)
+		} else if msg.HTML == "" {
+			msg.HTML = body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plainText := strings.TrimSpace(strings.Join(plain, "\n"))
+	msg.Text = plainText
+	if msg.HTML != "" {
+		doc := htmltext.Convert(msg.HTML)
+		msg.Links = doc.Links
+		if len([]rune(plainText)) < minPlainRunes && len([]rune(doc.Text)) > len([]rune(plainText)) {
+			msg.Text = doc.Text
+		}
+	}
+	return msg, nil
+}
+
+// EmailMessage converts m to the domain type under the given ID. Attachments
+// are left out: extracting their text (PDF / DOCX parsing) is slow, so
+// repositories add ExtractAttachments when the pipeline fetches the message.
+func (m *Message) EmailMessage(id message.ID) *message.EmailMessage {
+	return &message.EmailMessage{
+		ID:              id,
+		Subject:         m.Subject,
+		Body:            m.Text,
+		MessageIDHeader: m.MessageID(),
+		From:            m.From(),
+		To:              m.To(),
+		Date:            m.Date(),
+		ThreadID:        m.ThreadID(),
+		Labels:          m.Labels(),
+		Links:           m.Links,
+		HTMLBody:        m.HTML,
+	}
+}
+
+// ExtractAttachments converts the raw attachments to text, skipping (and
+// logging) the ones that cannot be read, e.g. scanned PDFs.
+func (m *Message) ExtractAttachments() []message.Attachment {
+	var out []message.Attachment
+	for _, a := range m.Attachments {
+		text, err := attachment.Extract(a.Filename, a.MimeType, a.Data)
+		if err != nil {
+			log.Printf("[mailparse] skipping attachment %s: %v", a.Filename, err)
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		mt, _, _ := mime.ParseMediaType(a.MimeType)
+		out = append(out, message.Attachment{Filename: a.Filename, MimeType: mt, Text: text})
+	}
+	return out
+}
+
+// MessageID returns the Message-ID header.
+func (m *Message) MessageID() string {
+	return strings.TrimSpace(m.Header.Get("Message-Id"))
+}
+
+// Date returns the parsed Date header, or the zero time when missing or invalid.
+func (m *Message) Date() time.Time {
+	t, err := m.Header.Date()
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// From returns the decoded From header.
+func (m *Message) From() string {
+	return DecodeHeader(m.Header.Get("From"))
+}
+
+// To returns the decoded To header.
+func (m *Message) To() string {
+	return DecodeHeader(m.Header.Get("To"))
+}
+
+// ThreadID returns the Gmail thread ID recorded by Google Takeout (X-GM-THRID).
+func (m *Message) ThreadID() string {
+	return strings.TrimSpace(m.Header.Get("X-GM-THRID"))
+}
+
+// Labels returns the Gmail labels recorded by Google Takeout (X-Gmail-Labels).
+func (m *Message) Labels() []string {
+	v := DecodeHeader(m.Header.Get("X-Gmail-Labels"))
+	if v == "" {
+		return nil
+	}
+	var labels []string
+	for _, l := range strings.Split(v, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// DecodeHeader decodes RFC 2047 encoded-words (e.g. =?ISO-2022-JP?B?...?=).
+func DecodeHeader(v string) string {
+	if d, err := wordDecoder.DecodeHeader(v); err == nil {
+		return strings.TrimSpace(d)
+	}
+	return strings.TrimSpace(v)
+}
+
+// header is satisfied by both mail.Header and textproto.MIMEHeader.
+type header interface {
+	Get(key string) string
+}
+
+// part is a leaf of the MIME tree.
+type part struct {
+	mediaType   string
+	contentType string // raw Content-Type value
+	params      map[string]string
+	filename    string
+	attachment  bool // Content-Disposition: attachment
+	encoding    string
+	body        io.Reader
+}
+
+// walk visits every leaf of a MIME tree, passing it to fn.
+func walk(h header, body io.Reader, fn func(part) error) error {
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = "text/plain; charset=us-ascii"
+	}
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		// 壊れた Content-Type はプレーンテキストとして扱う
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read multipart: %w", err)
+			}
+			if err := walk(p.Header, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	p := part{
+		mediaType:   mediaType,
+		contentType: ct,
+		params:      params,
+		encoding:    h.Get("Content-Transfer-Encoding"),
+		body:        body,
+	}
+	if disp, dparams, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		p.attachment = disp == "attachment"
+		p.filename = DecodeHeader(dparams["filename"])
+	}
+	if p.filename == "" {
+		p.filename = DecodeHeader(params["name"])
+	}
+	return fn(p)
+}
+
+// decodeTransfer undoes the Content-Transfer-Encoding.
+func decodeTransfer(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		// 改行入りの base64 も受け付ける
+		clean := strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, string(raw))
+		data, err := base64.StdEncoding.DecodeString(clean)
+		if err != nil {
+			return nil, fmt.Errorf("decode base64 body: %w", err)
+		}
+		return data, nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	return data, nil
+}
+
+// decodeBody undoes the transfer encoding and converts the charset to UTF-8.
+func decodeBody(body io.Reader, encoding, cs string) (string, error) {
+	data, err := decodeTransfer(body, encoding)
+	if err != nil {
+		return "", err
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if cs != "" && !strings.EqualFold(cs, "utf-8") && !strings.EqualFold(cs, "us-ascii") {
+		if cr, err := charset.NewReaderLabel(cs, r); err == nil {
+			r = cr
+		}
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	return strings.ReplaceAll(string(out), "\r\n", "\n"), nil
+}
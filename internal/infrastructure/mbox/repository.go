@@ -0,0 +1,108 @@
+package mbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gmail-tts-app/internal/domain/message"
+	"gmail-tts-app/internal/infrastructure/mailparse"
+)
+
+// Entry describes a message found in the archive.
+type Entry struct {
+	ID              message.ID
+	MessageIDHeader string
+	Subject         string
+	Date            time.Time
+}
+
+// Repository implements domain message.Repository for messages imported from
+// an mbox archive. Load only remembers where the messages accepted by its
+// filter start; GetByID reads and parses a message again when the pipeline
+// fetches it, so archives larger than memory (multi-year Takeout exports) work.
+type Repository struct {
+	src     io.ReaderAt
+	mu      sync.Mutex
+	offsets map[message.ID]int64
+}
+
+// NewRepository reads the archive from src, typically the opened mbox file.
+func NewRepository(src io.ReaderAt) *Repository {
+	return &Repository{src: src, offsets: make(map[message.ID]int64)}
+}
+
+// IDFor derives a stable message ID from the Message-ID header (or the raw
+// message when the header is missing), so reimports map to the same state.
+func IDFor(messageIDHeader string, raw []byte) message.ID {
+	key := []byte(messageIDHeader)
+	if len(key) == 0 {
+		key = raw
+	}
+	sum := sha256.Sum256(key)
+	return message.ID("mbox-" + hex.EncodeToString(sum[:8]))
+}
+
+// Load streams the archive and keeps the messages for which match returns
+// true. Each message is parsed for match and dropped right after. Entries are
+// returned oldest first.
+func (r *Repository) Load(match func(*mailparse.Message) bool) ([]Entry, error) {
+	sc := NewScanner(io.NewSectionReader(r.src, 0, math.MaxInt64))
+	var entries []Entry
+	total := 0
+	for sc.Scan() {
+		total++
+		raw := sc.Message()
+		m, err := mailparse.Parse(bytes.NewReader(raw))
+		if err != nil {
+			log.Printf("[mbox] skipping unparsable message #%d: %v", total, err)
+			continue
+		}
+		if !match(m) {
+			continue
+		}
+		id := IDFor(m.MessageID(), raw)
+		r.mu.Lock()
+		r.offsets[id] = sc.Offset()
+		r.mu.Unlock()
+		entries = append(entries, Entry{ID: id, MessageIDHeader: m.MessageID(), Subject: m.Subject, Date: m.Date()})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read mbox: %w", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+	log.Printf("[mbox] scanned %d message(s), %d matched", total, len(entries))
+	return entries, nil
+}
+
+// GetByID reads a message found by Load back from the archive.
+func (r *Repository) GetByID(ctx context.Context, id message.ID) (*message.EmailMessage, error) {
+	r.mu.Lock()
+	off, ok := r.offsets[id]
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("mbox message %s is not loaded", id)
+	}
+	sc := NewScanner(io.NewSectionReader(r.src, off, math.MaxInt64-off))
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("read mbox message %s: %w", id, err)
+		}
+		return nil, fmt.Errorf("mbox message %s not found at offset %d", id, off)
+	}
+	m, err := mailparse.Parse(bytes.NewReader(sc.Message()))
+	if err != nil {
+		return nil, fmt.Errorf("parse mbox message %s: %w", id, err)
+	}
+	msg := m.EmailMessage(id)
+	msg.Attachments = m.ExtractAttachments()
+	return msg, nil
+}
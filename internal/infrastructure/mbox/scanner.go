@@ -0,0 +1,106 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Scanner streams raw messages out of an mbox file (mboxo/mboxrd, as written
+// by Google Takeout) without loading the whole archive into memory.
+type Scanner struct {
+	r         *bufio.Reader
+	pos       int64  // bytes read so far
+	next      []byte // "From " separator line already read for the next message
+	nextStart int64  // offset of next
+	start     int64  // offset of the separator line of msg
+	msg       []byte
+	err       error
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Scan advances to the next message. It returns false at the end of the
+// archive or on error (see Err).
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	var buf bytes.Buffer
+	started := s.next != nil
+	start := s.nextStart
+	s.next = nil
+	prevBlank := true
+	for {
+		line, err := s.r.ReadBytes('\n')
+		lineStart := s.pos
+		s.pos += int64(len(line))
+		if len(line) > 0 {
+			if bytes.HasPrefix(line, []byte("From ")) && prevBlank {
+				if started {
+					s.next, s.nextStart = line, lineStart
+					s.msg, s.start = trimTrailingNewline(buf.Bytes()), start
+					return true
+				}
+				// 先頭の区切り行
+				started, start = true, lineStart
+			} else if started {
+				buf.Write(unescapeFrom(line))
+			}
+			prevBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+		}
+		if err == io.EOF {
+			s.err = io.EOF
+			if started && buf.Len() > 0 {
+				s.msg, s.start = trimTrailingNewline(buf.Bytes()), start
+				return true
+			}
+			return false
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+	}
+}
+
+// Message returns the raw RFC 822 message found by the last Scan.
+func (s *Scanner) Message() []byte {
+	return s.msg
+}
+
+// Offset returns where the message found by the last Scan starts in the
+// archive (its "From " separator line). A Scanner reading from that offset
+// finds the same message first.
+func (s *Scanner) Offset() int64 {
+	return s.start
+}
+
+// Err returns the first non-EOF error encountered.
+func (s *Scanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// unescapeFrom undoes mboxrd quoting (">From " -> "From ", ">>From " -> ">From ").
+func unescapeFrom(line []byte) []byte {
+	i := 0
+	for i < len(line) && line[i] == '>' {
+		i++
+	}
+	if i > 0 && bytes.HasPrefix(line[i:], []byte("From ")) {
+		return line[1:]
+	}
+	return line
+}
+
+// trimTrailingNewline drops the blank line that separates messages.
+func trimTrailingNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	b = bytes.TrimSuffix(b, []byte("\r"))
+	return append([]byte(nil), b...)
+}
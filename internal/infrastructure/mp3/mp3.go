@@ -0,0 +1,116 @@
+// Package mp3 reads MPEG audio frame headers, enough to measure the duration
+// of concatenated TTS output and to make silence matching it.
+package mp3
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// Layer III bitrates in kbps by bitrate index.
+	bitratesV1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	bitratesV2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	// sample rates by version (1, 2, 2.5) and sample rate index.
+	sampleRates = map[int][3]int{1: {44100, 48000, 32000}, 2: {22050, 24000, 16000}, 25: {11025, 12000, 8000}}
+)
+
+// frame is a parsed Layer III frame header.
+type frame struct {
+	sampleRate int
+	samples    int // per frame
+	length     int // bytes including the header
+}
+
+// parseHeader parses the Layer III frame header at the start of b.
+func parseHeader(b []byte) (frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return frame{}, false
+	}
+	var version int
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		version = 25
+	case 2:
+		version = 2
+	case 3:
+		version = 1
+	default:
+		return frame{}, false
+	}
+	if (b[1]>>1)&3 != 1 { // Layer III のみ
+		return frame{}, false
+	}
+	brIdx, srIdx, padding := int(b[2]>>4), int(b[2]>>2)&3, int(b[2]>>1)&1
+	if srIdx == 3 {
+		return frame{}, false
+	}
+	f := frame{sampleRate: sampleRates[version][srIdx], samples: 576}
+	bitrate, coef := bitratesV2[brIdx], 72000
+	if version == 1 {
+		bitrate, coef, f.samples = bitratesV1[brIdx], 144000, 1152
+	}
+	if bitrate == 0 {
+		return frame{}, false
+	}
+	f.length = coef*bitrate/f.sampleRate + padding
+	return f, true
+}
+
+// id3Size returns the size of the ID3v2 tag at the start of b, or 0.
+func id3Size(b []byte) int {
+	if len(b) < 10 || string(b[:3]) != "ID3" {
+		return 0
+	}
+	size := int(b[6]&0x7F)<<21 | int(b[7]&0x7F)<<14 | int(b[8]&0x7F)<<7 | int(b[9]&0x7F)
+	if b[5]&0x10 != 0 { // footer present
+		size += 10
+	}
+	return 10 + size
+}
+
+// Duration returns the playing time of data, which may be several MP3 files
+// concatenated (ID3 tags between them are skipped).
+func Duration(data []byte) time.Duration {
+	var d time.Duration
+	for i := 0; i+4 <= len(data); {
+		if n := id3Size(data[i:]); n > 0 {
+			i += n
+			continue
+		}
+		f, ok := parseHeader(data[i:])
+		if !ok || i+f.length > len(data) {
+			i++ // 同期が取れるまで1バイトずつ進める
+			continue
+		}
+		d += time.Duration(f.samples) * time.Second / time.Duration(f.sampleRate)
+		i += f.length
+	}
+	return d
+}
+
+// Silence returns silent frames of about d in the format of the first frame
+// of sample, so they can be concatenated with it.
+func Silence(sample []byte, d time.Duration) ([]byte, error) {
+	i := id3Size(sample)
+	for ; i+4 <= len(sample); i++ {
+		if _, ok := parseHeader(sample[i:]); ok {
+			break
+		}
+	}
+	if i+4 > len(sample) {
+		return nil, errors.New("no mp3 frame in sample")
+	}
+	hdr := [4]byte{sample[i], sample[i+1] | 0x01, sample[i+2] &^ 0x02, sample[i+3]} // CRC なし・パディングなし
+	f, _ := parseHeader(hdr[:])
+	perFrame := time.Duration(f.samples) * time.Second / time.Duration(f.sampleRate)
+	n := int((d + perFrame - 1) / perFrame)
+	out := make([]byte, 0, n*f.length)
+	// サイド情報・メインデータがすべて0のフレームは無音として復号される
+	frameData := make([]byte, f.length)
+	copy(frameData, hdr[:])
+	for k := 0; k < n; k++ {
+		out = append(out, frameData...)
+	}
+	return out, nil
+}
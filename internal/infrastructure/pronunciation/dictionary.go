@@ -0,0 +1,208 @@
+// Package pronunciation rewrites terms the TTS reads badly (product names,
+// abbreviations, proper nouns) into readings before synthesis.
+package pronunciation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entry maps a term to its reading. Term is literal text unless Regex is set,
+// in which case Reading may use $1-style references. WholeWord only matches
+// where the term is not part of a longer Latin word or number ("AI" in
+// "AI搭載" but not in "OpenAI"). Literal terms match case-insensitively
+// unless CaseSensitive is set.
+type Entry struct {
+	Term          string `json:"term"`
+	Reading       string `json:"reading"`
+	Regex         bool   `json:"regex,omitempty"`
+	WholeWord     bool   `json:"whole_word,omitempty"`
+	CaseSensitive bool   `json:"case_sensitive,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// File is the JSON format of the dictionary file.
+type File struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Dictionary applies its entries in one pass over the text.
+type Dictionary struct {
+	entries     []Entry
+	fingerprint string
+}
+
+// Load reads the dictionary file. A missing file (or empty path) is an empty dictionary.
+func Load(path string) (*Dictionary, error) {
+	if path == "" {
+		return New(nil)
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	d, err := New(f.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// New compiles the entries.
+func New(entries []Entry) (*Dictionary, error) {
+	d := &Dictionary{}
+	h := sha256.New()
+	for i, e := range entries {
+		if e.Term == "" {
+			return nil, fmt.Errorf("entry %d: term is required", i+1)
+		}
+		pattern := e.Term
+		if !e.Regex {
+			pattern = regexp.QuoteMeta(e.Term)
+		}
+		if !e.CaseSensitive && !e.Regex {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d (%s): %w", i+1, e.Term, err)
+		}
+		e.re = re
+		d.entries = append(d.entries, e)
+		fmt.Fprintf(h, "%s\x00%s\x00%t%t%t\x00", e.Term, e.Reading, e.Regex, e.WholeWord, e.CaseSensitive)
+	}
+	if len(d.entries) > 0 {
+		d.fingerprint = hex.EncodeToString(h.Sum(nil))
+	}
+	return d, nil
+}
+
+// Len is the number of entries.
+func (d *Dictionary) Len() int { return len(d.entries) }
+
+// Fingerprint identifies the entries, so audio made with another dictionary
+// is not reused. It is empty for an empty dictionary.
+func (d *Dictionary) Fingerprint() string { return d.fingerprint }
+
+type match struct {
+	start, end int
+	reading    string
+}
+
+// Apply replaces every term with its reading. All entries are matched against
+// the original text; where matches overlap the one starting first wins, then
+// the longer one, so "OpenAI" is not read as "Open" + the reading of "AI".
+// Readings are never matched again. Full-width Latin letters and digits are
+// read as half-width ones.
+func (d *Dictionary) Apply(text string) string {
+	if len(d.entries) == 0 {
+		return text
+	}
+	text = normalizeWidth(text)
+	var ms []match
+	for _, e := range d.entries {
+		for _, loc := range e.re.FindAllStringSubmatchIndex(text, -1) {
+			if loc[0] == loc[1] || (e.WholeWord && !wholeWord(text, loc[0], loc[1])) {
+				continue
+			}
+			var reading []byte
+			if e.Regex {
+				reading = e.re.ExpandString(nil, e.Reading, text, loc)
+			} else {
+				reading = []byte(e.Reading)
+			}
+			ms = append(ms, match{start: loc[0], end: loc[1], reading: string(reading)})
+		}
+	}
+	if len(ms) == 0 {
+		return text
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].start != ms[j].start {
+			return ms[i].start < ms[j].start
+		}
+		return ms[i].end > ms[j].end
+	})
+	var b strings.Builder
+	last := 0
+	for _, m := range ms {
+		if m.start < last {
+			continue // 先に採用した一致と重なる
+		}
+		b.WriteString(text[last:m.start])
+		b.WriteString(m.reading)
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// wholeWord reports whether text[start:end] is not glued to Latin letters or digits.
+func wholeWord(text string, start, end int) bool {
+	return (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end]))
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// latinTermRe matches Latin-script words such as "LLM", "GPT-4o", "C++", "Node.js".
+var latinTermRe = regexp.MustCompile(`[A-Za-z][A-Za-z0-9]*(?:[.\-+&'][A-Za-z0-9+]+)*\+*`)
+
+var urlRe = regexp.MustCompile(`(?i)(?:https?://|www\.)\S+|\S+@\S+\.\S+`)
+
+// Term is a Latin-script term and how often it occurs.
+type Term struct {
+	Text  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// UnknownTerms lists the Latin-script terms (two or more letters) of text the
+// dictionary leaves as they are, most frequent first. URLs and mail addresses
+// are ignored.
+func (d *Dictionary) UnknownTerms(text string) []Term {
+	text = urlRe.ReplaceAllString(normalizeWidth(text), " ")
+	text = d.Apply(text)
+	counts := map[string]int{}
+	var order []string
+	for _, t := range latinTermRe.FindAllString(text, -1) {
+		if len(t) < 2 {
+			continue
+		}
+		if counts[t] == 0 {
+			order = append(order, t)
+		}
+		counts[t]++
+	}
+	terms := make([]Term, 0, len(order))
+	for _, t := range order {
+		terms = append(terms, Term{Text: t, Count: counts[t]})
+	}
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Count > terms[j].Count })
+	return terms
+}
+
+// normalizeWidth maps full-width Latin letters and digits (ＡＩ, ３) to half-width.
+func normalizeWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'Ａ' && r <= 'Ｚ' || r >= 'ａ' && r <= 'ｚ' || r >= '０' && r <= '９' {
+			return r - 0xFEE0
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PushEnvelope is the JSON body Pub/Sub POSTs to a push subscription endpoint.
+type PushEnvelope struct {
+	Message struct {
+		Data        string            `json:"data"` // base64 encoded payload
+		MessageID   string            `json:"messageId"`
+		PublishTime string            `json:"publishTime"`
+		Attributes  map[string]string `json:"attributes"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// GmailNotification is the payload Gmail publishes when a watched mailbox changes.
+type GmailNotification struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryID    uint64 `json:"historyId"`
+}
+
+// DecodeGmailPush parses a Pub/Sub push body and decodes the Gmail notification in it.
+func DecodeGmailPush(body []byte) (*GmailNotification, error) {
+	var env PushEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("decode push envelope: %w", err)
+	}
+	if env.Message.Data == "" {
+		return nil, errors.New("push message has no data")
+	}
+	data, err := base64.StdEncoding.DecodeString(env.Message.Data)
+	if err != nil {
+		// 一部のクライアントは URL-safe base64 で送ってくる
+		if data, err = base64.URLEncoding.DecodeString(env.Message.Data); err != nil {
+			return nil, fmt.Errorf("decode push data: %w", err)
+		}
+	}
+	var n GmailNotification
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("decode gmail notification: %w", err)
+	}
+	if n.EmailAddress == "" || n.HistoryID == 0 {
+		return nil, fmt.Errorf("incomplete gmail notification: %s", string(data))
+	}
+	return &n, nil
+}
+
+// NewGmailPushHandler returns a fiber handler for the push endpoint. When token is
+// set, requests must carry it as the "token" query parameter (configured in the
+// subscription's push URL). Each valid notification is passed to onNotify, which
+// must not block. Bodies that cannot be decoded are logged and acknowledged:
+// Pub/Sub redelivers anything answered with a non-2xx status, and a malformed
+// payload would fail the same way on every delivery until it expires.
+func NewGmailPushHandler(token string, onNotify func(GmailNotification)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusForbidden, "invalid token")
+		}
+		n, err := DecodeGmailPush(c.Body())
+		if err != nil {
+			log.Printf("[push] dropping undecodable push: %v", err)
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		log.Printf("[push] notification: email=%s historyId=%d", n.EmailAddress, n.HistoryID)
+		onNotify(*n)
+		// 2xx を返すと Pub/Sub は ack 扱いにする
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,255 @@
+package statestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"gmail-tts-app/internal/domain/state"
+)
+
+var (
+	bucketMessages = []byte("messages")
+	bucketCursors  = []byte("gmail_history")
+	bucketMeta     = []byte("meta")
+	// bucketHeaders indexes records by RFC 822 Message-ID header.
+	bucketHeaders = []byte("message_id_headers")
+
+	keyLegacyImported        = []byte("legacy_imported")
+	keyLegacyHistoryImported = []byte("legacy_history_imported")
+)
+
+// BoltStore implements state.Store on an embedded bbolt database. It also
+// implements gmail.HistoryStore so sync cursors live next to message state.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the database at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	if path == "" {
+		path = "state.db"
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open state db %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketMessages, bucketCursors, bucketMeta, bucketHeaders} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init state db: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the record for id, or nil if absent.
+func (s *BoltStore) Get(id string) (*state.Record, error) {
+	var rec *state.Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketMessages).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		rec = &state.Record{}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get state %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// Put stores rec in a single transaction.
+func (s *BoltStore) Put(rec *state.Record) error {
+	if rec == nil || rec.MessageID == "" {
+		return errors.New("state record requires message id")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if rec.MessageIDHeader != "" {
+			if err := tx.Bucket(bucketHeaders).Put([]byte(rec.MessageIDHeader), []byte(rec.MessageID)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketMessages).Put([]byte(rec.MessageID), data)
+	})
+}
+
+// FindByMessageIDHeader returns the record indexed under the RFC 822 Message-ID header.
+func (s *BoltStore) FindByMessageIDHeader(header string) (*state.Record, error) {
+	if header == "" {
+		return nil, nil
+	}
+	var id []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketHeaders).Get([]byte(header)); v != nil {
+			id = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || id == nil {
+		return nil, err
+	}
+	return s.Get(string(id))
+}
+
+// List returns all records ordered by creation time.
+func (s *BoltStore) List() ([]*state.Record, error) {
+	var recs []*state.Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMessages).ForEach(func(_, v []byte) error {
+			rec := &state.Record{}
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].CreatedAt.Before(recs[j].CreatedAt) })
+	return recs, nil
+}
+
+// LoadHistoryID returns the stored Gmail historyId for key (0 if none).
+func (s *BoltStore) LoadHistoryID(key string) (uint64, error) {
+	var id uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketCursors).Get([]byte(key)); len(v) == 8 {
+			id = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return id, err
+}
+
+// SaveHistoryID stores the Gmail historyId for key.
+func (s *BoltStore) SaveHistoryID(key string, id uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, id)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCursors).Put([]byte(key), v)
+	})
+}
+
+// ImportLegacyIDs imports the message IDs listed one per line in the old
+// procced_mail_ids.txt as done records. It runs once per database; later calls
+// are no-ops. IDs that already have a record are left untouched.
+func (s *BoltStore) ImportLegacyIDs(path string) (int, error) {
+	imported := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyLegacyImported) != nil {
+			return nil
+		}
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return meta.Put(keyLegacyImported, []byte(time.Now().Format(time.RFC3339)))
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		msgs := tx.Bucket(bucketMessages)
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			id := strings.TrimSpace(sc.Text())
+			if id == "" || msgs.Get([]byte(id)) != nil {
+				continue
+			}
+			rec := state.NewRecord(id)
+			rec.Done()
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := msgs.Put([]byte(id), data); err != nil {
+				return err
+			}
+			imported++
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		return meta.Put(keyLegacyImported, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", path, err)
+	}
+	if imported > 0 {
+		log.Printf("[state] imported %d processed id(s) from %s", imported, path)
+	}
+	return imported, nil
+}
+
+// ImportLegacyHistory imports the Gmail sync cursors of the old
+// gmail_history.json ({"<query>": historyId}), so the first incremental sync
+// after the upgrade continues where the file left off instead of doing a full
+// sync. It runs once per database; cursors already in the store are kept.
+func (s *BoltStore) ImportLegacyHistory(path string) (int, error) {
+	imported := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyLegacyHistoryImported) != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return meta.Put(keyLegacyHistoryImported, []byte(time.Now().Format(time.RFC3339)))
+		}
+		if err != nil {
+			return err
+		}
+		var cursors map[string]uint64
+		if err := json.Unmarshal(data, &cursors); err != nil {
+			return err
+		}
+
+		b := tx.Bucket(bucketCursors)
+		for key, id := range cursors {
+			if id == 0 || b.Get([]byte(key)) != nil {
+				continue
+			}
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, id)
+			if err := b.Put([]byte(key), v); err != nil {
+				return err
+			}
+			imported++
+		}
+		return meta.Put(keyLegacyHistoryImported, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", path, err)
+	}
+	if imported > 0 {
+		log.Printf("[state] imported %d gmail history cursor(s) from %s", imported, path)
+	}
+	return imported, nil
+}
@@ -0,0 +1,173 @@
+package textclean
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gmail-tts-app/internal/domain/message"
+)
+
+// Detectors are the built-in cleanup steps by name.
+var Detectors = map[string]func(string) string{
+	"header":    StripBrowserHeader,
+	"quotes":    StripQuotes,
+	"signature": StripSignature,
+	"footer":    StripFooter,
+}
+
+// detectorOrder is the order detectors run in, whatever order they are configured in.
+var detectorOrder = []string{"header", "quotes", "signature", "footer"}
+
+// Rule is a regex cleanup rule. From / Subject restrict the rule to messages
+// whose sender / subject contain the given text (case-insensitive), so rules
+// can target a single newsletter.
+type Rule struct {
+	Name    string `json:"name"`
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RulesFile is the JSON format of the rules file.
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadRules reads the rules file. A missing file means no rules.
+func LoadRules(path string) ([]Rule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f RulesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f.Rules, nil
+}
+
+// Source identifies the message being cleaned, for rule matching.
+type Source struct {
+	From    string
+	Subject string
+}
+
+// Step is the outcome of one detector or rule.
+type Step struct {
+	Name    string `json:"name"`
+	Removed int    `json:"removed_chars"`
+}
+
+// Report tells how much each step removed, in characters (runes).
+type Report struct {
+	Before int    `json:"before_chars"`
+	After  int    `json:"after_chars"`
+	Steps  []Step `json:"steps"`
+}
+
+// Removed is the total number of characters removed.
+func (r Report) Removed() int {
+	return r.Before - r.After
+}
+
+// Cleaner runs the enabled detectors and then the matching rules.
+type Cleaner struct {
+	detectors []string
+	rules     []Rule
+}
+
+// NewCleaner validates the detector names and compiles the rules.
+func NewCleaner(detectors []string, rules []Rule) (*Cleaner, error) {
+	enabled := map[string]bool{}
+	for _, d := range detectors {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if _, ok := Detectors[d]; !ok {
+			return nil, fmt.Errorf("unknown cleanup detector %q", d)
+		}
+		enabled[d] = true
+	}
+	c := &Cleaner{}
+	for _, d := range detectorOrder {
+		if enabled[d] {
+			c.detectors = append(c.detectors, d)
+		}
+	}
+	for i, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, r.Name, err)
+		}
+		r.re = re
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		c.rules = append(c.rules, r)
+	}
+	return c, nil
+}
+
+// Clean returns text with the detected noise removed and a report per step.
+// Chapters (separated by form feeds) are cleaned one by one, so the footer of
+// the mail body is found even when attachment chapters follow it.
+func (c *Cleaner) Clean(text string, src Source) (string, Report) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	rep := Report{Before: utf8.RuneCountInString(text)}
+	removed := map[string]int{}
+	var names []string
+	apply := func(chapter, name string, fn func(string) string) string {
+		if _, ok := removed[name]; !ok {
+			names = append(names, name)
+		}
+		out := fn(chapter)
+		removed[name] += utf8.RuneCountInString(chapter) - utf8.RuneCountInString(out)
+		return out
+	}
+
+	chapters := strings.Split(text, message.ChapterBreak)
+	for i, ch := range chapters {
+		for _, d := range c.detectors {
+			ch = apply(ch, d, Detectors[d])
+		}
+		for _, r := range c.rules {
+			if r.matches(src) {
+				re, repl := r.re, r.Replace
+				ch = apply(ch, "rule:"+r.Name, func(s string) string { return re.ReplaceAllString(s, repl) })
+			}
+		}
+		chapters[i] = strings.TrimSpace(collapseBlankLines(ch))
+	}
+	text = strings.Join(chapters, "\n"+message.ChapterBreak+"\n")
+
+	for _, n := range names {
+		rep.Steps = append(rep.Steps, Step{Name: n, Removed: removed[n]})
+	}
+	rep.After = utf8.RuneCountInString(text)
+	return text, rep
+}
+
+func (r Rule) matches(src Source) bool {
+	if r.From != "" && !strings.Contains(strings.ToLower(src.From), strings.ToLower(r.From)) {
+		return false
+	}
+	if r.Subject != "" && !strings.Contains(strings.ToLower(src.Subject), strings.ToLower(r.Subject)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package textclean
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// footerKeywords appear in unsubscribe sections and legal boilerplate.
+// They are specific enough not to show up in an article near its end.
+var footerKeywords = []string{
+	"unsubscribe", "manage your subscription", "update your preferences", "email preferences",
+	"you are receiving this", "you received this email", "all rights reserved", "copyright ©", "copyright (c)", "© 20",
+	"配信停止", "配信解除", "配信の停止", "購読解除", "購読の解除", "登録解除", "登録の解除",
+	"このメールは送信専用", "無断転載",
+}
+
+// footerTail is the share of the text (from the end) in which a footer may start.
+const footerTail = 0.3
+
+// StripFooter cuts the text from the first paragraph in the last part of the
+// text that mentions unsubscribing, copyright and the like. A separator line
+// right above that paragraph goes too.
+func StripFooter(text string) string {
+	paras := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n")
+	total := utf8.RuneCountInString(text)
+	offset := 0
+	for i, p := range paras {
+		inTail := float64(offset) >= float64(total)*(1-footerTail) || i >= len(paras)-2
+		offset += utf8.RuneCountInString(p) + 2
+		if !inTail || i == 0 || !hasFooterKeyword(p) {
+			continue
+		}
+		cut := i
+		if cut > 1 && signatureRuleRe.MatchString(strings.TrimSpace(paras[cut-1])) {
+			cut--
+		}
+		// 段落内で区切り線の後から始まっているならそこから切る
+		head := footerStartInParagraph(p)
+		if head == "" {
+			return strings.TrimSpace(strings.Join(paras[:cut], "\n\n"))
+		}
+		return strings.TrimSpace(strings.Join(append(paras[:i:i], head), "\n\n"))
+	}
+	return strings.TrimSpace(text)
+}
+
+// footerStartInParagraph returns the lines of p before the footer when the
+// footer starts after a separator line inside p, or "" when p is all footer.
+func footerStartInParagraph(p string) string {
+	lines := strings.Split(p, "\n")
+	for i, l := range lines {
+		if hasFooterKeyword(l) {
+			for j := i; j > 0; j-- {
+				if signatureRuleRe.MatchString(lines[j-1]) {
+					return strings.TrimSpace(strings.Join(lines[:j-1], "\n"))
+				}
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func hasFooterKeyword(s string) bool {
+	return containsAny(strings.ToLower(s), footerKeywords)
+}
+
+// browserHeaderKeywords appear in the "view in browser" line above newsletters.
+var browserHeaderKeywords = []string{
+	"view in browser", "view this email in your browser", "view it in your browser", "view online",
+	"having trouble viewing", "ブラウザで見る", "ブラウザで表示", "ブラウザでご覧", "web版", "ウェブ版",
+	"正しく表示されない",
+}
+
+// headerLines is how many leading lines StripBrowserHeader looks at.
+const headerLines = 10
+
+// StripBrowserHeader drops "View in browser" style lines near the top.
+func StripBrowserHeader(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	for i, l := range lines {
+		if i < headerLines && containsAny(strings.ToLower(l), browserHeaderKeywords) {
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func containsAny(s string, keywords []string) bool {
+	for _, k := range keywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package textclean
+
+import (
+	"regexp"
+	"strings"
+)
+
+// replyHeaderRes match the line a mail client puts above the quoted message.
+var replyHeaderRes = []*regexp.Regexp{
+	regexp.MustCompile(`^On .+wrote:\s*$`),
+	// 2024年1月2日(火) 10:00 Name <a@example.com>: （見出しと区別するため、コロンの前にアドレスが必要）
+	regexp.MustCompile(`^\d{4}年\d{1,2}月\d{1,2}日.*(<[^<>]+>|[\w.+-]+@[\w-]+(\.[\w-]+)+).*[:：]\s*$`),
+	regexp.MustCompile(`^\d{4}/\d{1,2}/\d{1,2}.*(wrote|書きました)[:：]?\s*$`), // 2024/01/02 10:00、Name のメッセージ:
+	regexp.MustCompile(`^-{2,}\s*(Original Message|元のメッセージ|Forwarded message|転送されたメッセージ)\s*-{2,}\s*$`),
+}
+
+// outlookRuleRe matches the rule Outlook puts above the headers of the quoted
+// message; outlookFromRe the "From:" line that must follow it.
+var (
+	outlookRuleRe = regexp.MustCompile(`^_{10,}$`)
+	outlookFromRe = regexp.MustCompile(`^(From|差出人)\s*[:：]`)
+)
+
+// QuoteHeader reports whether lines[i] introduces a quoted message. An
+// underscore rule only does when an Outlook "From:" / "差出人:" line follows it.
+func QuoteHeader(lines []string, i int) bool {
+	line := strings.TrimSpace(lines[i])
+	for _, re := range replyHeaderRes {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return outlookRuleRe.MatchString(line) && i+1 < len(lines) && outlookFromRe.MatchString(strings.TrimSpace(lines[i+1]))
+}
+
+// StripQuotes removes ">"-quoted lines and everything from a reply header
+// ("On ... wrote:", "-----Original Message-----", ...) to the end.
+func StripQuotes(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	for i, l := range lines {
+		if QuoteHeader(lines, i) {
+			break
+		}
+		if strings.HasPrefix(strings.TrimLeft(l, " \t"), ">") {
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.TrimSpace(collapseBlankLines(strings.Join(out, "\n")))
+}
+
+// signatureRuleRe matches separator lines that usually open a signature block.
+var signatureRuleRe = regexp.MustCompile(`^\s*([-=_*~#+]|━|─|＿|＝){10,}\s*$`)
+
+// maxSignatureLines bounds how far from the end a ruled signature may start,
+// so separators inside the body are kept.
+const maxSignatureLines = 12
+
+// SignatureStart returns the index of the line where the signature of lines
+// starts, or -1. Both the RFC 3676 delimiter "-- " and a rule line only count
+// within the last maxSignatureLines lines, and the delimiter only when it is
+// the last one, so "--" used as a section divider keeps the text after it.
+func SignatureStart(lines []string) int {
+	from := len(lines) - maxSignatureLines
+	if from < 1 {
+		from = 1 // 本文が区切り線で始まる場合は署名とみなさない
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if l := lines[i]; l == "-- " || l == "--" {
+			if i >= from {
+				return i
+			}
+			break
+		}
+	}
+	for i := from; i < len(lines); i++ {
+		if signatureRuleRe.MatchString(lines[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// StripSignature removes the trailing signature block.
+func StripSignature(text string) string {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n "), "\n")
+	if i := SignatureStart(lines); i >= 0 {
+		lines = lines[:i]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Reply strips quotes and then the signature, leaving what the sender wrote.
+func Reply(text string) string {
+	return StripSignature(StripQuotes(text))
+}
+
+var blankLinesRe = regexp.MustCompile(`\n{3,}`)
+
+func collapseBlankLines(s string) string {
+	return blankLinesRe.ReplaceAllString(s, "\n\n")
+}
@@ -0,0 +1,196 @@
+package textclean
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuoteHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		lines []string
+		want  bool
+	}{
+		{"gmail english", []string{"On Tue, Jan 2, 2024 at 10:00 AM Taro <taro@example.com> wrote:"}, true},
+		{"gmail japanese", []string{"2024年1月2日(火) 10:00 Taro <taro@example.com>:"}, true},
+		{"gmail japanese, bare address", []string{"2024年1月2日(火) 10:00 taro@example.com："}, true},
+		{"japanese heading ending with a colon", []string{"2024年5月10日の勉強会について："}, false},
+		{"japanese date with a time only", []string{"2024年5月10日 10:00 開始:"}, false},
+		{"apple mail japanese", []string{"2024/01/02 10:00、Taro <taro@example.com>のメール書きました:"}, true},
+		{"original message", []string{"-----Original Message-----"}, true},
+		{"forwarded message", []string{"---------- Forwarded message ---------"}, true},
+		{"japanese original message", []string{"-----元のメッセージ-----"}, true},
+		{"outlook rule and From", []string{"________________________________", "From: Taro <taro@example.com>"}, true},
+		{"outlook rule and 差出人", []string{"________________________________", "差出人: 山田 太郎"}, true},
+		{"underscore rule alone", []string{"________________________________", "次の話題です。"}, false},
+		{"underscore rule at the end", []string{"________________________________"}, false},
+		{"plain text", []string{"Thanks for the update."}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := QuoteHeader(tc.lines, 0); got != tc.want {
+				t.Errorf("QuoteHeader(%q) = %t, want %t", tc.lines, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripQuotes(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "quoted lines",
+			in:   "了解です。\n> 明日の件ですが\n> よろしくお願いします\n\n追記です。",
+			want: "了解です。\n\n追記です。",
+		},
+		{
+			name: "reply header drops the rest",
+			in:   "賛成です。\n\nOn Tue, Jan 2, 2024 at 10:00 AM Taro <taro@example.com> wrote:\n前のメールの本文",
+			want: "賛成です。",
+		},
+		{
+			name: "outlook quote",
+			in:   "Sounds good.\n\n________________________________\nFrom: Taro <taro@example.com>\nSent: Tuesday\n\nOld text",
+			want: "Sounds good.",
+		},
+		{
+			name: "dated heading is kept",
+			in:   "お知らせです。\n\n2024年5月10日の勉強会について：\n会場は本社です。",
+			want: "お知らせです。\n\n2024年5月10日の勉強会について：\n会場は本社です。",
+		},
+		{
+			name: "underscore divider is kept",
+			in:   "第1部\n\n____________________\n\n第2部",
+			want: "第1部\n\n____________________\n\n第2部",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripQuotes(tc.in); got != tc.want {
+				t.Errorf("StripQuotes(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripSignature(t *testing.T) {
+	longBody := strings.Repeat("本文の段落です。\n", maxSignatureLines)
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "rfc 3676 delimiter",
+			in:   "本文です。\n\n-- \n山田 太郎\nExample株式会社",
+			want: "本文です。",
+		},
+		{
+			name: "last delimiter only",
+			in:   "本文1\n--\n本文2\n\n-- \n山田 太郎",
+			want: "本文1\n--\n本文2",
+		},
+		{
+			name: "divider far from the end is kept",
+			in:   "本文1\n--\n" + longBody + "本文2",
+			want: "本文1\n--\n" + longBody + "本文2",
+		},
+		{
+			name: "last delimiter near the end starts the signature",
+			in:   "本文1\n--\n本文2\n--\n本文3",
+			want: "本文1\n--\n本文2",
+		},
+		{
+			name: "rule line near the end",
+			in:   "本文です。\n\n==========\n山田 太郎\ntaro@example.com",
+			want: "本文です。",
+		},
+		{
+			name: "rule line far from the end is kept",
+			in:   "本文です。\n==========\n" + longBody + "おわり",
+			want: "本文です。\n==========\n" + longBody + "おわり",
+		},
+		{
+			name: "leading rule is kept",
+			in:   "==========\nタイトル\n本文です。",
+			want: "==========\nタイトル\n本文です。",
+		},
+		{
+			name: "no signature",
+			in:   "本文です。\n\nまた来週。",
+			want: "本文です。\n\nまた来週。",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripSignature(tc.in); got != tc.want {
+				t.Errorf("StripSignature(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReply(t *testing.T) {
+	in := "いいですね。\n\n-- \nHanako\n\n2024年1月2日(火) 10:00 Taro <taro@example.com>:\n> 明日どうですか"
+	if got, want := Reply(in), "いいですね。"; got != want {
+		t.Errorf("Reply(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestStripFooter(t *testing.T) {
+	article := strings.Repeat("今週の記事の本文です。", 20)
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "unsubscribe paragraph with the rule above it",
+			in:   "今週のニュース\n\n" + article + "\n\n----------\n\n配信停止はこちら: https://example.com/u\n© 2024 Example",
+			want: "今週のニュース\n\n" + article,
+		},
+		{
+			name: "footer after a rule inside the last paragraph",
+			in:   "今週のニュース\n\n" + article + "\n----------\nTo unsubscribe, click here.",
+			want: "今週のニュース\n\n" + article,
+		},
+		{
+			name: "keyword early in a long text is kept",
+			in:   "今週のニュース\n\n配信停止の手順が変わりました。\n\n" + article + "\n\n" + article + "\n\n" + article + "\n\nまた来週。",
+			want: "今週のニュース\n\n配信停止の手順が変わりました。\n\n" + article + "\n\n" + article + "\n\n" + article + "\n\nまた来週。",
+		},
+		{
+			name: "no footer",
+			in:   "今週のニュース\n\n" + article,
+			want: "今週のニュース\n\n" + article,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripFooter(tc.in); got != tc.want {
+				t.Errorf("StripFooter(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStripBrowserHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "english",
+			in:   "View this email in your browser\n\nWeekly news\nBody",
+			want: "Weekly news\nBody",
+		},
+		{
+			name: "japanese",
+			in:   "メールが正しく表示されない場合はこちら\nブラウザで見る\n今週の話題",
+			want: "今週の話題",
+		},
+		{
+			name: "far from the top is kept",
+			in:   strings.Repeat("本文\n", headerLines) + "ブラウザで見る",
+			want: strings.TrimSpace(strings.Repeat("本文\n", headerLines) + "ブラウザで見る"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StripBrowserHeader(tc.in); got != tc.want {
+				t.Errorf("StripBrowserHeader(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
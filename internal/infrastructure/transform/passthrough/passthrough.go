@@ -0,0 +1,21 @@
+package passthrough
+
+import (
+	"context"
+
+	"gmail-tts-app/internal/domain/transform"
+)
+
+// Transformer implements transform.Transformer by returning the input as is,
+// for narrating the cleaned text without an LLM.
+type Transformer struct{}
+
+var _ transform.Transformer = Transformer{}
+
+// New returns the passthrough transformer.
+func New() Transformer { return Transformer{} }
+
+// Transform returns input unchanged.
+func (Transformer) Transform(_ context.Context, _ string, input string) (string, error) {
+	return input, nil
+}
@@ -7,15 +7,18 @@ import (
     "fmt"
     "io"
     "net/http"
-    "os"
-    "path/filepath"
+    "strconv"
     "strings"
     "time"
 
+    "gmail-tts-app/internal/chunker"
     "gmail-tts-app/internal/config"
     "gmail-tts-app/internal/domain/tts"
 )
 
+// InputLimits is the input size limit of the speech endpoint (4096 characters).
+var InputLimits = chunker.Limits{MaxChars: 4096}
+
 // Synthesizer implements tts.Synthesizer using OpenAI TTS endpoint.
 type Synthesizer struct {
 	apiKey         string
@@ -25,22 +28,13 @@ type Synthesizer struct {
 	responseFormat string
 }
 
-// NewSynthesizer creates OpenAI TTS synthesizer.
-// If apiKey is empty, it tries environment variable OPENAI_API_KEY or file openai_api_key.txt.
-func NewSynthesizer(apiKey string) (*Synthesizer, error) {
-	if apiKey == "" {
-		apiKey = getOpenAIKey()
-	}
+// NewSynthesizerWithConfig creates OpenAI TTS synthesizer with the given voice settings
+// (config.Config.TTS with the overrides of a profile applied).
+func NewSynthesizerWithConfig(apiKey string, ttsConfig config.TTSConfig) (*Synthesizer, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("openai api key is required")
 	}
 
-	// Load TTS configuration from tts.config file
-	ttsConfig, err := config.LoadTTSConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load TTS config: %w", err)
-	}
-
 	return &Synthesizer{
 		apiKey:         apiKey,
 		voice:          ttsConfig.Voice,
@@ -81,22 +75,38 @@ func (s *Synthesizer) Synthesize(ctx context.Context, text string) (*tts.Audio,
 		defer cancel()
 	}
 
-	req, err := http.NewRequestWithContext(reqCtx, "POST", "https://api.openai.com/v1/audio/speech", bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(reqCtx, "POST", "https://api.openai.com/v1/audio/speech", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
 		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("openai error %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		resp.Body.Close()
+		apiErr := fmt.Errorf("openai error %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+		// レート制限（429）は待って再送する。利用枠切れ（insufficient_quota）は待っても直らない
+		if resp.StatusCode != http.StatusTooManyRequests || strings.Contains(string(b), "insufficient_quota") || attempt == maxRateLimitRetries {
+			return nil, apiErr
+		}
+		wait := retryAfter(resp.Header, attempt)
+		fmt.Printf("[tts] rate limited, retrying in %s (%d/%d)\n", wait, attempt+1, maxRateLimitRetries)
+		select {
+		case <-time.After(wait):
+		case <-reqCtx.Done():
+			return nil, fmt.Errorf("%w (while waiting to retry: %v)", apiErr, reqCtx.Err())
+		}
 	}
+	defer resp.Body.Close()
 	
 	fmt.Printf("[tts] Response received, reading audio data...\n")
 	
@@ -112,21 +122,17 @@ func (s *Synthesizer) Synthesize(ctx context.Context, text string) (*tts.Audio,
 	
 	return &tts.Audio{Data: audioBytes, Format: "mp3"}, nil
 }
-// Stream synth is unused in CLI mode and intentionally omitted.
 
-// getOpenAIKey returns the OpenAI API key.
-// Priority: env OPENAI_API_KEY > file openai_api_key.txt
-func getOpenAIKey() string {
-	if k := os.Getenv("OPENAI_API_KEY"); k != "" {
-		return k
-	}
-	secretsDir := os.Getenv("SECRETS_DIR")
-	if secretsDir == "" {
-		secretsDir = "secrets"
-	}
-	path := filepath.Join(secretsDir, "openai_api_key.txt")
-	if data, err := os.ReadFile(path); err == nil {
-		return strings.TrimSpace(string(data))
+// maxRateLimitRetries is how often a request rejected with 429 is resent.
+const maxRateLimitRetries = 4
+
+// retryAfter is the wait the server asked for (Retry-After seconds), else an
+// exponential backoff from one second.
+func retryAfter(h http.Header, attempt int) time.Duration {
+	if sec, err := strconv.Atoi(strings.TrimSpace(h.Get("Retry-After"))); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
 	}
-	return ""
+	return time.Second << attempt
 }
+
+// Stream synth is unused in CLI mode and intentionally omitted.
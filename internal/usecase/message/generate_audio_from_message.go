@@ -10,9 +10,11 @@ import (
 	"strings"
 	"time"
 
+	"gmail-tts-app/internal/chunker"
 	"gmail-tts-app/internal/domain/audio"
 	domainmsg "gmail-tts-app/internal/domain/message"
 	"gmail-tts-app/internal/domain/tts"
+	"gmail-tts-app/internal/workpool"
 )
 
 // GenerateAudioFromMessageInput is input DTO.
@@ -34,10 +36,20 @@ type GenerateAudioFromMessage struct {
 	repo        domainmsg.Repository
 	synthesizer tts.Synthesizer
 	store       audio.Store
+	workers     int               // chunks synthesized at once
+	limiter     *workpool.Limiter // synthesize calls per minute (nil = no limit)
 }
 
 func NewGenerateAudioFromMessage(repo domainmsg.Repository, synth tts.Synthesizer, store audio.Store) *GenerateAudioFromMessage {
-	return &GenerateAudioFromMessage{repo: repo, synthesizer: synth, store: store}
+	return &GenerateAudioFromMessage{repo: repo, synthesizer: synth, store: store, workers: 1}
+}
+
+// WithConcurrency synthesizes up to workers chunks at once, starting at most
+// requestsPerMinute synthesize calls per minute (0 = no limit). Returns uc.
+func (uc *GenerateAudioFromMessage) WithConcurrency(workers, requestsPerMinute int) *GenerateAudioFromMessage {
+	uc.workers = workers
+	uc.limiter = workpool.NewLimiter(requestsPerMinute)
+	return uc
 }
 
 // Execute converts message body to audio and save via store.
@@ -59,27 +71,41 @@ func (uc *GenerateAudioFromMessage) Execute(ctx context.Context, in *GenerateAud
 		fileName = string(msg.ID) // fallback to ID if subject is empty or invalid
 	}
 
-	// Split into chunks (<=1500 runes)
-	const chunkSize = 1500
-	chunks := splitByRuneCount(text, chunkSize)
+	// Split into chunks (<=1500 runes) at paragraph / sentence boundaries
+	chunks := chunker.Split(text, chunker.Limits{MaxChars: 1500})
 
-	var merged []byte
-	for i, part := range chunks {
+	// Synthesize chunks in parallel; the first error cancels the others
+	parts := make([][]byte, len(chunks))
+	err = workpool.Run(ctx, len(chunks), uc.workers, func(ctx context.Context, i int) error {
+		part := chunks[i]
+		if err := uc.limiter.Wait(ctx); err != nil {
+			return err
+		}
 		log.Printf("[uc] synthesize part %d/%d runes=%d", i+1, len(chunks), len([]rune(part)))
 		partCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 		audioObj, err := uc.synthesizer.Synthesize(partCtx, part)
 		cancel()
 		if err != nil {
 			log.Printf("[uc] synthesize error on part %d: %v", i+1, err)
-			return nil, err
+			return err
 		}
 		// Save individual chunk for debugging
 		partFile := fmt.Sprintf("parts/%s_part%d", fileName, i+1)
 		if _, err := uc.store.Save(audioObj.Data, partFile); err != nil {
 			log.Printf("[uc] save part error: %v", err)
-			return nil, err
+			return err
 		}
-		merged = append(merged, audioObj.Data...)
+		parts[i] = audioObj.Data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reassemble in chunk order
+	var merged []byte
+	for _, data := range parts {
+		merged = append(merged, data...)
 	}
 
 	log.Printf("[uc] all parts synthesized, total bytes=%d", len(merged))
@@ -147,26 +173,3 @@ func truncateRunes(s string, n int) string {
 	}
 	return string(r[:n])
 }
-
-// splitByRuneCount splits string s into chunks each having at most n runes.
-func splitByRuneCount(s string, n int) []string {
-	if n <= 0 || runeCount(s) <= n {
-		return []string{s}
-	}
-	var res []string
-	var builder strings.Builder
-	count := 0
-	for _, r := range s {
-		builder.WriteRune(r)
-		count++
-		if count >= n {
-			res = append(res, builder.String())
-			builder.Reset()
-			count = 0
-		}
-	}
-	if builder.Len() > 0 {
-		res = append(res, builder.String())
-	}
-	return res
-}
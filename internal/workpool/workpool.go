@@ -0,0 +1,113 @@
+// Package workpool runs indexed jobs on a bounded number of goroutines and
+// spaces out the API requests they make.
+package workpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Run calls fn for the jobs 0..n-1 on at most workers goroutines (fewer than 1
+// means one at a time) and waits for them. The first error cancels the context
+// passed to the running jobs, skips the ones not started yet and is returned.
+// When ctx ends before every job was started, its error is returned; once all
+// of them were started, their outcome alone decides. Results belong in slots
+// indexed by i, so they keep the job order.
+func Run(ctx context.Context, n, workers int, fn func(ctx context.Context, i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	fed := 0
+feed:
+	for ; fed < n; fed++ {
+		select {
+		case jobs <- fed:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if fed < n {
+		// 親の ctx が先に終わり、未着手のジョブが残った
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Limiter spaces requests evenly so that at most perMinute start in any
+// minute, across all the goroutines sharing it. A nil Limiter does not limit.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a limiter for perMinute requests per minute, or nil
+// (no limit) when perMinute is 0 or less.
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// Wait blocks until the next request may start or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
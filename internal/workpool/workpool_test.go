@@ -0,0 +1,177 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunKeepsOrder(t *testing.T) {
+	const n, workers = 50, 4
+	results := make([]int, n)
+	var active, maxActive atomic.Int32
+	err := Run(context.Background(), n, workers, func(ctx context.Context, i int) error {
+		a := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if a <= m || maxActive.CompareAndSwap(m, a) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		results[i] = i * i
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r != i*i {
+			t.Fatalf("results[%d] = %d, want %d", i, r, i*i)
+		}
+	}
+	if m := maxActive.Load(); m > workers {
+		t.Errorf("%d jobs ran at once, want at most %d", m, workers)
+	}
+}
+
+func TestRunCancelsAfterError(t *testing.T) {
+	const n = 100
+	boom := errors.New("boom")
+	var started atomic.Int32
+	var cancelled atomic.Bool
+	err := Run(context.Background(), n, 2, func(ctx context.Context, i int) error {
+		started.Add(1)
+		if i == 3 {
+			return boom
+		}
+		select {
+		case <-ctx.Done():
+			cancelled.Store(true)
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+			return nil
+		}
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Run = %v, want the first error", err)
+	}
+	if s := started.Load(); s >= n {
+		t.Errorf("all %d jobs started after an error", s)
+	}
+	if !cancelled.Load() {
+		t.Error("the running job did not see its context cancelled")
+	}
+}
+
+func TestRunParentCancelled(t *testing.T) {
+	t.Run("after every job started", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			wg.Wait()
+			cancel()
+		}()
+		// 全ジョブが着手済みで成功したなら、親のキャンセルは失敗にしない
+		err := Run(ctx, 3, 3, func(ctx context.Context, i int) error {
+			wg.Done()
+			<-ctx.Done()
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Run = %v, want nil", err)
+		}
+	})
+	t.Run("before every job started", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var started atomic.Int32
+		err := Run(ctx, 10, 1, func(ctx context.Context, i int) error {
+			started.Add(1)
+			cancel()
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+		if s := started.Load(); s >= 10 {
+			t.Errorf("%d jobs started after the cancel", s)
+		}
+	})
+}
+
+func TestRunNoJobs(t *testing.T) {
+	if err := Run(context.Background(), 0, 3, func(context.Context, int) error {
+		t.Error("job called")
+		return nil
+	}); err != nil {
+		t.Errorf("Run = %v", err)
+	}
+}
+
+func TestLimiterSpacing(t *testing.T) {
+	const perMinute, calls = 6000, 6 // 10ms 間隔
+	l := NewLimiter(perMinute)
+	var mu sync.Mutex
+	var starts []time.Time
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			starts = append(starts, time.Now())
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(starts) != calls {
+		t.Fatalf("%d calls returned, want %d", len(starts), calls)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	// i 番目の呼び出しは、どの順に呼ばれても最初から i 間隔より前には戻らない
+	interval := time.Minute / perMinute
+	for i, at := range starts {
+		if d, want := at.Sub(begin), time.Duration(i)*interval; d < want {
+			t.Errorf("call %d returned after %s, want at least %s", i, d, want)
+		}
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := NewLimiter(0)
+	if l != nil {
+		t.Fatalf("NewLimiter(0) = %v, want nil", l)
+	}
+	begin := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(begin); d > 50*time.Millisecond {
+		t.Errorf("nil limiter waited %s", d)
+	}
+}